@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"sort"
+)
+
+const (
+	// fieldManager owns the fields mesh-helper sets with server-side apply.
+	fieldManager = "mesh-helper"
+	// managedByLabel marks the objects generated by mesh-helper so stale ones can be pruned by later runs.
+	managedByLabel = "app.kubernetes.io/managed-by"
+)
+
+var managedLabels = map[string]string{managedByLabel: fieldManager}
+
+var resourcesByKind = map[string]schema.GroupVersionResource{
+	"AuthorizationPolicy": {Group: "security.istio.io", Version: "v1beta1", Resource: "authorizationpolicies"},
+	"Sidecar":             {Group: "networking.istio.io", Version: "v1", Resource: "sidecars"},
+	"NetworkPolicy":       {Group: "networking.k8s.io", Version: "v1", Resource: "networkpolicies"},
+}
+
+const (
+	applyCreated     = "created"
+	applyConfigured  = "configured"
+	applyUnchanged   = "unchanged"
+	applyConflicting = "conflicting"
+	applyPruned      = "pruned"
+	applyFailed      = "failed"
+)
+
+type applyResult struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Result    string
+	Message   string
+}
+
+// applyIstioObjects server-side applies the generated objects and, with --prune, deletes the mesh-helper managed
+// objects of the same kind that were not generated this time. Conflicting or failed objects are returned as an
+// error once all results are printed.
+func applyIstioObjects(ctx context.Context, globalFlags *GlobalFlags, policies []runtime.Object, args *DependenciesArgs) error {
+	if args.DryRun != "none" && args.DryRun != "server" {
+		return fmt.Errorf("unsupported --dry-run value %q, use none or server", args.DryRun)
+	}
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return err
+	}
+	var dryRun []string
+	if args.DryRun == "server" {
+		dryRun = []string{metav1.DryRunAll}
+	}
+
+	var results []*applyResult
+	applied := map[string]bool{}
+	for _, policy := range policies {
+		result, err := applyObject(ctx, client.Dynamic(), policy, dryRun)
+		if err != nil {
+			return err
+		}
+		applied[result.Kind+"/"+result.Namespace+"/"+result.Name] = true
+		results = append(results, result)
+	}
+
+	if args.Prune {
+		pruned, err := pruneObjects(ctx, client.Dynamic(), kindForOutput(args.Output), args.Namespace, applied, dryRun)
+		if err != nil {
+			return err
+		}
+		results = append(results, pruned...)
+	}
+
+	printApplyResults(results, args.DryRun == "server")
+	var failed int
+	for _, result := range results {
+		if result.Result == applyConflicting || result.Result == applyFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d object(s) could not be applied or pruned", failed)
+	}
+	return nil
+}
+
+func applyObject(ctx context.Context, client dynamic.Interface, policy runtime.Object, dryRun []string) (*applyResult, error) {
+	objMeta, err := meta.Accessor(policy)
+	if err != nil {
+		return nil, err
+	}
+	kind := policy.GetObjectKind().GroupVersionKind().Kind
+	result := &applyResult{Kind: kind, Namespace: objMeta.GetNamespace(), Name: objMeta.GetName()}
+	gvr, ok := resourcesByKind[kind]
+	if !ok {
+		return nil, fmt.Errorf("can not apply objects of kind %s", kind)
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	resource := client.Resource(gvr).Namespace(result.Namespace)
+
+	existing, err := resource.Get(ctx, result.Name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	if apierrors.IsNotFound(err) {
+		existing = nil
+	}
+
+	updated, err := resource.Patch(ctx, result.Name, types.ApplyPatchType, data, metav1.PatchOptions{
+		FieldManager: fieldManager,
+		DryRun:       dryRun,
+	})
+	switch {
+	case apierrors.IsConflict(err):
+		result.Result = applyConflicting
+		result.Message = err.Error()
+	case err != nil:
+		result.Result = applyFailed
+		result.Message = err.Error()
+	case existing == nil:
+		result.Result = applyCreated
+	case equality.Semantic.DeepEqual(existing.Object["spec"], updated.Object["spec"]) &&
+		equality.Semantic.DeepEqual(existing.GetLabels(), updated.GetLabels()):
+		result.Result = applyUnchanged
+	default:
+		result.Result = applyConfigured
+	}
+	return result, nil
+}
+
+func pruneObjects(ctx context.Context, client dynamic.Interface, kind string, namespace string, applied map[string]bool, dryRun []string) ([]*applyResult, error) {
+	managed, err := client.Resource(resourcesByKind[kind]).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + fieldManager,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*applyResult
+	for _, obj := range managed.Items {
+		if applied[kind+"/"+obj.GetNamespace()+"/"+obj.GetName()] {
+			continue
+		}
+		result := &applyResult{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName(), Result: applyPruned}
+		err := client.Resource(resourcesByKind[kind]).Namespace(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{
+			DryRun: dryRun,
+		})
+		if err != nil {
+			result.Result = applyFailed
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func printApplyResults(results []*applyResult, dryRun bool) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Namespace != results[j].Namespace {
+			return results[i].Namespace < results[j].Namespace
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	tbl := newTable("Kind", "Namespace", "Name", "Result", "Message")
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result.Result]++
+		status := result.Result
+		if result.Result == applyConflicting || result.Result == applyFailed {
+			status = color.RedString(status)
+		}
+		tbl.AddRow(result.Kind, result.Namespace, result.Name, status, result.Message)
+	}
+	tbl.Print()
+
+	suffix := ""
+	if dryRun {
+		suffix = " (server dry run)"
+	}
+	fmt.Printf("\n%d created, %d configured, %d unchanged, %d conflicting, %d pruned, %d failed%s\n",
+		counts[applyCreated], counts[applyConfigured], counts[applyUnchanged], counts[applyConflicting],
+		counts[applyPruned], counts[applyFailed], suffix)
+}
+
+// kindForOutput returns the kind of objects generated by the --output format.
+func kindForOutput(output string) string {
+	switch output {
+	case "sidecar":
+		return "Sidecar"
+	case "networkpolicy":
+		return "NetworkPolicy"
+	}
+	return "AuthorizationPolicy"
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/authz"
+	"github.com/nmnellis/mesh-helper/internal/manifest"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"sort"
+	"strings"
+	"time"
+)
+
+type AuthzSimulateArgs struct {
+	Policies      string
+	File          string
+	PromURL       string
+	Metric        string
+	Name          string
+	Namespace     string
+	RootNamespace string
+	BlockedOnly   bool
+}
+
+func authzSimulateCmd() *cobra.Command {
+	simArgs := &AuthzSimulateArgs{}
+	cmd := &cobra.Command{
+		Use:   "authz-simulate",
+		Short: "Replay observed traffic against AuthorizationPolicy manifests",
+		Long: `Replay every observed request edge against Istio AuthorizationPolicies read from YAML files, using Istio's
+CUSTOM, DENY, ALLOW evaluation order, and report which requests would be blocked. The command exits with an
+error when at least one request would be blocked, or when a policy could not be evaluated, so it can gate
+policy changes in CI.
+
+Ports, methods, paths and hosts are only known when the metrics carry the destination_port, request_method,
+request_path and request_host labels. Rules that depend on data missing from the capture never match for ALLOW
+policies and always match for DENY policies, the same way Istio treats HTTP-only fields on TCP traffic.
+Destinations only carry the app and version labels found in the metrics, the app label defaults to the workload
+name when destination_app is missing. Policies selecting other labels, or an app the workload name starts with
+such as reviews for reviews-v1, are reported as unevaluated and the decision is marked with a ?. CUSTOM policies are reported with their provider but not enforced, the
+external authorizer's decision is unknown.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runAuthzSimulate(simArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&simArgs.Policies, "policies", "", "File or directory of AuthorizationPolicy manifests")
+	cmd.Flags().StringVarP(&simArgs.File, "file", "f", "", "Read traffic from a prometheus formatted input file")
+	cmd.Flags().StringVar(&simArgs.PromURL, "prom-url", "", "Call prometheus directly to fetch traffic")
+	cmd.Flags().StringVar(&simArgs.Metric, "metric", "istio_tcp_sent_bytes_total", "Metric to replay traffic from (istio_tcp_sent_bytes_total, istio_requests_total)")
+	cmd.Flags().StringVar(&simArgs.Name, "name", "", "Filter for destination workload by name")
+	cmd.Flags().StringVarP(&simArgs.Namespace, "namespace", "n", "", "Filter for destination workloads in a namespace")
+	cmd.Flags().StringVar(&simArgs.RootNamespace, "root-namespace", "istio-system", "Istio root namespace, policies in it apply mesh wide")
+	cmd.Flags().BoolVar(&simArgs.BlockedOnly, "blocked-only", false, "Only print requests that would be blocked")
+
+	cmd.MarkFlagRequired("policies")
+	return cmd
+}
+
+func runAuthzSimulate(args *AuthzSimulateArgs) error {
+	objects, err := manifest.LoadFromPath(args.Policies)
+	if err != nil {
+		return err
+	}
+	policies := manifest.AuthorizationPolicies(objects)
+	fmt.Println("found", len(policies), "AuthorizationPolicy(s)")
+
+	fakeAPI, err := loadPromAPI(args.File, args.PromURL, args.Metric)
+	if err != nil {
+		return err
+	}
+
+	requests, err := queryRequestEdges(fakeAPI, args.Namespace, args.Name, args.Metric)
+	if err != nil {
+		return err
+	}
+
+	simulator := authz.NewSimulator(args.RootNamespace, policies)
+
+	tbl := newTable("Source", "Destination", "Port", "Decision", "Policy", "Reason")
+	var blocked, uncertain int
+	for _, req := range requests {
+		decision := simulator.Evaluate(req)
+		if decision.Uncertain() {
+			uncertain++
+		}
+		if decision.Blocked() {
+			blocked++
+		} else if args.BlockedOnly {
+			continue
+		}
+		reason := decision.Reason
+		if decision.Uncertain() {
+			reason += fmt.Sprintf(", unevaluated %s selecting labels missing from the metrics", strings.Join(decision.Unevaluated, " "))
+		}
+		tbl.AddRow(
+			fmt.Sprintf("%s.%s", req.SourceWorkload, req.SourceNamespace),
+			fmt.Sprintf("%s.%s", req.DestinationWorkload, req.DestinationNamespace),
+			req.Port,
+			formatDecision(decision),
+			decision.Policy,
+			reason,
+		)
+	}
+	tbl.Print()
+
+	fmt.Printf("\n%d of %d request(s) would be blocked\n", blocked, len(requests))
+	if uncertain > 0 {
+		fmt.Printf("%d request(s) have unevaluated policies, their decision may differ in the cluster\n", uncertain)
+	}
+	if blocked > 0 {
+		return errors.New("the authorization policies would block observed traffic")
+	}
+	if uncertain > 0 {
+		return errors.New("the authorization policies could not be fully evaluated against observed traffic")
+	}
+	return nil
+}
+
+func formatDecision(decision *authz.Decision) string {
+	text := decision.Action
+	if decision.Audited {
+		text += "+AUDIT"
+	}
+	if decision.Custom != "" {
+		text += fmt.Sprintf(" (CUSTOM %s)", decision.Custom)
+	}
+	if decision.Uncertain() {
+		text += "?"
+	}
+	if decision.Blocked() {
+		return color.RedString(text)
+	}
+	if decision.Uncertain() {
+		return color.YellowString(text)
+	}
+	return text
+}
+
+// queryRequestEdges returns one request per observed source and destination pair, filtered by the destination.
+func queryRequestEdges(api *prom.FakeAPI, namespace string, nameFilter string, metric string) ([]*authz.Request, error) {
+	var filter string
+	if nameFilter != "" {
+		filter = "destination_workload=~\"" + nameFilter + ".*\", "
+	}
+	if namespace != "" {
+		filter += "destination_workload_namespace=\"" + namespace + "\""
+	}
+	query := fmt.Sprintf("sum(%s{%s}) by (source_workload,source_workload_namespace,source_principal,"+
+		"destination_workload,destination_workload_namespace,destination_app,destination_version,"+
+		"request_protocol,destination_port,request_method,request_path,request_host)", metric, filter)
+
+	output, _, err := api.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	vector, ok := output.(model.Vector)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value type %q", output.Type())
+	}
+
+	var requests []*authz.Request
+	for _, sample := range vector {
+		m := sample.Metric
+		if m["destination_workload"] == "" || m["destination_workload"] == "unknown" {
+			continue
+		}
+		// without destination_app the app label is assumed to be the workload name, the convention of the policies
+		// generated by dependencies, and a selector on a prefix of it such as app: reviews can not be ruled out
+		labels := map[string]string{"app": string(m["destination_workload"])}
+		inferred := map[string]bool{"app": true}
+		if app := string(m["destination_app"]); app != "" && app != "unknown" {
+			labels["app"] = app
+			delete(inferred, "app")
+		}
+		if version := string(m["destination_version"]); version != "" && version != "unknown" {
+			labels["version"] = version
+		}
+		protocol := string(m["request_protocol"])
+		requests = append(requests, &authz.Request{
+			SourceWorkload:       string(m["source_workload"]),
+			SourcePrincipal:      unknownToEmpty(string(m["source_principal"])),
+			SourceNamespace:      unknownToEmpty(string(m["source_workload_namespace"])),
+			DestinationWorkload:  string(m["destination_workload"]),
+			DestinationNamespace: string(m["destination_workload_namespace"]),
+			DestinationLabels:    labels,
+			InferredLabels:       inferred,
+			Port:                 string(m["destination_port"]),
+			HTTP:                 protocol == "http" || protocol == "grpc",
+			Method:               string(m["request_method"]),
+			Path:                 string(m["request_path"]),
+			Host:                 string(m["request_host"]),
+		})
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].DestinationNamespace != requests[j].DestinationNamespace {
+			return requests[i].DestinationNamespace < requests[j].DestinationNamespace
+		}
+		if requests[i].DestinationWorkload != requests[j].DestinationWorkload {
+			return requests[i].DestinationWorkload < requests[j].DestinationWorkload
+		}
+		return requests[i].SourceWorkload < requests[j].SourceWorkload
+	})
+	return requests, nil
+}
+
+// unknownToEmpty converts the "unknown" placeholder Istio uses in metric labels to an empty value.
+func unknownToEmpty(value string) string {
+	if value == "unknown" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/domain"
+	"github.com/spf13/cobra"
+	"sort"
+	"strings"
+	"time"
+)
+
+type BlastRadiusArgs struct {
+	Name      string
+	Namespace string
+	File      string
+	PromURL   string
+	Metric    string
+	Window    time.Duration
+	Output    string
+}
+
+// blastRadius is everything upstream of the target workloads.
+type blastRadius struct {
+	Targets []domain.Workload `json:"targets"`
+	Callers []*affectedCaller `json:"callers"`
+	Edges   []*domain.Edge    `json:"edges"`
+	Routes  []*affectedRoute  `json:"routes"`
+}
+
+// affectedCaller is a transitive caller of the target.
+type affectedCaller struct {
+	Workload domain.Workload `json:"workload"`
+	Hops     int             `json:"hops"`
+	// Value is the traffic the caller sends to affected workloads and targets.
+	Value float64 `json:"value"`
+	// Exposure is the estimated share of the caller's outbound traffic that depends on the target.
+	Exposure   float64 `json:"exposure"`
+	EntryPoint bool    `json:"entry_point"`
+}
+
+// affectedRoute is a call from an entry point, such as an ingress gateway, into the affected part of the graph.
+type affectedRoute struct {
+	EntryPoint domain.Workload `json:"entry_point"`
+	Service    string          `json:"service"`
+	Value      float64         `json:"value"`
+	Exposure   float64         `json:"exposure"`
+}
+
+func blastRadiusCmd() *cobra.Command {
+	blastArgs := &BlastRadiusArgs{}
+	cmd := &cobra.Command{
+		Use:   "blast-radius",
+		Short: "Show the callers and entry points affected when a workload fails",
+		Long: `Walk the dependency graph backwards from a workload to all of its transitive callers and the entry points, the
+callers nobody calls such as ingress gateways. The exposure of a caller estimates the share of its traffic that
+depends on the workload, assuming a workload spreads its calls over the requests it handles. The routes are the
+calls of the entry points into the affected part of the mesh, the user-facing traffic that would degrade.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBlastRadius(blastArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&blastArgs.Name, "name", "", "Name of the workload that fails")
+	cmd.Flags().StringVarP(&blastArgs.Namespace, "namespace", "n", "", "Namespace of the workload, all namespaces when not set")
+	cmd.Flags().StringVarP(&blastArgs.File, "file", "f", "", "Read from a prometheus formatted input file")
+	cmd.Flags().StringVar(&blastArgs.PromURL, "prom-url", "", "Call prometheus directly to fetch data")
+	cmd.Flags().StringVar(&blastArgs.Metric, "metric", "istio_tcp_sent_bytes_total", "Metric to weight the dependencies with (istio_tcp_sent_bytes_total, istio_requests_total)")
+	cmd.Flags().DurationVar(&blastArgs.Window, "window", 0, "Only count the traffic of the last window, requires --prom-url")
+	cmd.Flags().StringVarP(&blastArgs.Output, "output", "o", "table", "Output format (table, json)")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagsMutuallyExclusive("file", "prom-url")
+	return cmd
+}
+
+func runBlastRadius(args *BlastRadiusArgs) error {
+	if args.Output != "table" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+	api, err := loadPromAPIWindow(args.File, args.PromURL, args.Metric, args.Window)
+	if err != nil {
+		return err
+	}
+	graph, err := loadDependencyGraph(api, args.Metric)
+	if err != nil {
+		return err
+	}
+
+	var targets []domain.Workload
+	for _, w := range graph.Workloads() {
+		if w.Name == args.Name && (args.Namespace == "" || w.Namespace == args.Namespace) {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("workload %s has no traffic in %s", domain.Workload{Name: args.Name, Namespace: args.Namespace}, args.Metric)
+	}
+
+	radius := buildBlastRadius(graph, targets)
+	if args.Output == "json" {
+		data, err := json.MarshalIndent(radius, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printBlastRadius(radius, args.Metric)
+	return nil
+}
+
+func buildBlastRadius(graph *domain.Graph, targets []domain.Workload) *blastRadius {
+	radius := &blastRadius{Targets: targets, Callers: []*affectedCaller{}, Edges: []*domain.Edge{}, Routes: []*affectedRoute{}}
+	hops := graph.Upstream(targets)
+	exposure := graph.Exposure(targets)
+	affected := func(w domain.Workload) bool {
+		if _, ok := hops[w]; ok {
+			return true
+		}
+		for _, t := range targets {
+			if t == w {
+				return true
+			}
+		}
+		return false
+	}
+	exposureOf := func(w domain.Workload) float64 {
+		if _, ok := hops[w]; !ok && affected(w) {
+			return 1
+		}
+		return exposure[w]
+	}
+
+	for _, e := range graph.Edges {
+		if affected(e.Destination) && affected(e.Source) {
+			radius.Edges = append(radius.Edges, e)
+		}
+	}
+	for w, hop := range hops {
+		caller := &affectedCaller{Workload: w, Hops: hop, Exposure: exposure[w], EntryPoint: len(graph.Callers(w)) == 0}
+		for _, e := range graph.Callees(w) {
+			if affected(e.Destination) {
+				caller.Value += e.Value
+			}
+		}
+		radius.Callers = append(radius.Callers, caller)
+
+		if caller.EntryPoint {
+			for _, e := range graph.Callees(w) {
+				if affected(e.Destination) {
+					radius.Routes = append(radius.Routes, &affectedRoute{EntryPoint: w, Service: e.Service, Value: e.Value, Exposure: exposureOf(e.Destination)})
+				}
+			}
+		}
+	}
+
+	sort.Slice(radius.Callers, func(i, j int) bool {
+		a, b := radius.Callers[i], radius.Callers[j]
+		if a.Hops != b.Hops {
+			return a.Hops < b.Hops
+		}
+		if a.Exposure != b.Exposure {
+			return a.Exposure > b.Exposure
+		}
+		return a.Workload.String() < b.Workload.String()
+	})
+	sort.Slice(radius.Routes, func(i, j int) bool {
+		a, b := radius.Routes[i], radius.Routes[j]
+		if a.Value*a.Exposure != b.Value*b.Exposure {
+			return a.Value*a.Exposure > b.Value*b.Exposure
+		}
+		return a.EntryPoint.String()+a.Service < b.EntryPoint.String()+b.Service
+	})
+	return radius
+}
+
+func printBlastRadius(radius *blastRadius, metric string) {
+	var targets []string
+	for _, t := range radius.Targets {
+		targets = append(targets, t.String())
+	}
+	var entryPoints int
+	for _, c := range radius.Callers {
+		if c.EntryPoint {
+			entryPoints++
+		}
+	}
+	fmt.Printf("Blast radius of %s: %d caller(s), %d edge(s), %d entry point(s)\n\n",
+		strings.Join(targets, ", "), len(radius.Callers), len(radius.Edges), entryPoints)
+	if len(radius.Callers) == 0 {
+		fmt.Println("No workload calls it")
+		return
+	}
+
+	tbl := newTable("Caller", "Hops", "Traffic To Affected", "Exposure", "Entry Point")
+	for _, c := range radius.Callers {
+		tbl.AddRow(c.Workload.String(), c.Hops, formatValue(c.Value), fmt.Sprintf("%.1f%%", c.Exposure*100), yesNo(c.EntryPoint))
+	}
+	tbl.Print()
+
+	fmt.Println("\nUser-facing routes that would degrade")
+	tbl = newTable("Entry Point", "Service", "Traffic", "Exposure", "Degraded Traffic")
+	for _, r := range radius.Routes {
+		tbl.AddRow(r.EntryPoint.String(), r.Service, formatValue(r.Value), fmt.Sprintf("%.1f%%", r.Exposure*100), formatValue(r.Value*r.Exposure))
+	}
+	tbl.Print()
+	fmt.Printf("\nTraffic is the sum of %s\n", metric)
+}
+
+func formatValue(value float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", value), "0"), ".")
+}
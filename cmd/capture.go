@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"github.com/spf13/cobra"
+	"io"
+	"istio.io/istio/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type CaptureArgs struct {
+	PodSelectorArgs
+	PromURL        string
+	Metrics        []string
+	Output         string
+	CommandTimeout time.Duration
+	Concurrency    int
+}
+
+const (
+	bundleVersion      = 1
+	bundleManifestFile = "manifest.json"
+	bundleMetricsFile  = "metrics.json"
+	bundleClustersDir  = "clusters"
+	bundlePoliciesDir  = "policies"
+)
+
+// bundleManifest describes the content of a capture bundle.
+type bundleManifest struct {
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	KubeContext string    `json:"kube_context,omitempty"`
+	Prometheus  string    `json:"prometheus,omitempty"`
+	Metrics     []string  `json:"metrics,omitempty"`
+	// MetricsFile is in the promtool JSON format read by --file, empty when no prometheus was queried.
+	MetricsFile string `json:"metrics_file,omitempty"`
+	// ClustersDir holds the Envoy clusters JSON of every pod as <namespace>/<pod>.json.
+	ClustersDir string   `json:"clusters_dir"`
+	Pods        []string `json:"pods"`
+	Policies    []string `json:"policies"`
+}
+
+func captureCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
+	captureArgs := &CaptureArgs{}
+	cmd := &cobra.Command{
+		Use:   "capture",
+		Short: "Bundle the Istio metrics, Envoy clusters and policies of a cluster for offline analysis",
+		Long: `Write a single tarball with the Istio metrics from prometheus, the Envoy clusters JSON of every selected pod and
+the AuthorizationPolicies, Sidecars and PeerAuthentications, described by a manifest.json. The dependencies and
+endpoints commands read it back with --bundle. Without --namespace the pods of all namespaces are captured.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCapture(ctx, globalFlags, captureArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	addPodSelectorFlags(cmd, &captureArgs.PodSelectorArgs)
+	cmd.Flags().StringVar(&captureArgs.PromURL, "prom-url", "", "Prometheus to export the metrics from, the bundle has no metrics without it")
+	cmd.Flags().StringSliceVar(&captureArgs.Metrics, "metric", []string{"istio_requests_total", "istio_request_duration_milliseconds_bucket", "istio_tcp_sent_bytes_total", "istio_tcp_received_bytes_total"}, "Metrics to export")
+	cmd.Flags().StringVarP(&captureArgs.Output, "output", "o", "", "Bundle file to write (default mesh-capture-<time>.tar.gz)")
+	cmd.Flags().DurationVarP(&captureArgs.CommandTimeout, "timeout", "t", time.Minute*5, "Timeout")
+	cmd.Flags().IntVar(&captureArgs.Concurrency, "concurrency", 10, "Number of pods to collect endpoints from in parallel")
+	return cmd
+}
+
+func runCapture(ctx context.Context, globalFlags *GlobalFlags, args *CaptureArgs) error {
+	if args.Namespace == "" && args.PodName == "" && args.DeploymentName == "" && args.StatefulSet == "" &&
+		args.DaemonSet == "" && args.Rollout == "" && args.Service == "" {
+		args.AllNamespaces = true
+	}
+	if err := args.PodSelectorArgs.validate(); err != nil {
+		return err
+	}
+	if args.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	now := time.Now()
+	if args.Output == "" {
+		args.Output = fmt.Sprintf("mesh-capture-%s.tar.gz", now.Format("20060102-150405"))
+	}
+	manifest := &bundleManifest{
+		Version:     bundleVersion,
+		Created:     now.UTC(),
+		KubeContext: globalFlags.KubeContext,
+		ClustersDir: bundleClustersDir,
+		Pods:        []string{},
+		Policies:    []string{},
+	}
+	files := map[string][]byte{}
+
+	if args.PromURL != "" {
+		var metrics []prom.PromtoolJson
+		for _, metric := range args.Metrics {
+			exported, err := prom.ExportFromEndpoint(args.PromURL, metric)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, exported...)
+		}
+		data, err := json.Marshal(metrics)
+		if err != nil {
+			return err
+		}
+		files[bundleMetricsFile] = data
+		manifest.Prometheus = args.PromURL
+		manifest.Metrics = args.Metrics
+		manifest.MetricsFile = bundleMetricsFile
+		fmt.Fprintf(os.Stderr, "exported %d series from %s\n", len(metrics), args.PromURL)
+	} else {
+		fmt.Fprintln(os.Stderr, "no --prom-url, the bundle has no metrics")
+	}
+
+	if err := disableIstioInfoLogging(); err != nil {
+		return err
+	}
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, args.CommandTimeout)
+	defer cancel()
+
+	pods, err := findPods(ctx, client, &args.PodSelectorArgs)
+	if err != nil {
+		return err
+	}
+	endpointInfo, err := getEndpointInformation(ctx, pods, client, args.Concurrency)
+	if err != nil {
+		return err
+	}
+	for _, namespacePodName := range sortedPodNames(endpointInfo) {
+		data, err := json.MarshalIndent(endpointInfo[namespacePodName], "", "  ")
+		if err != nil {
+			return err
+		}
+		files[path.Join(bundleClustersDir, namespacePodName+".json")] = data
+		manifest.Pods = append(manifest.Pods, namespacePodName)
+	}
+
+	policies, err := loadMeshPolicies(ctx, client, args.Namespace)
+	if err != nil {
+		return err
+	}
+	serializer := newYAMLSerializer()
+	for _, policy := range policies {
+		objMeta, err := meta.Accessor(policy)
+		if err != nil {
+			return err
+		}
+		output, err := encodeIstioObject(serializer, policy)
+		if err != nil {
+			return err
+		}
+		kind := strings.ToLower(policy.GetObjectKind().GroupVersionKind().Kind)
+		name := path.Join(bundlePoliciesDir, objMeta.GetNamespace(), fmt.Sprintf("%s-%s.yaml", kind, objMeta.GetName()))
+		files[name] = []byte(output)
+		manifest.Policies = append(manifest.Policies, name)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	files[bundleManifestFile] = data
+	if err := writeBundle(args.Output, files); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s with %d pod(s) and %d policies\n", args.Output, len(manifest.Pods), len(manifest.Policies))
+	return nil
+}
+
+// loadMeshPolicies lists the AuthorizationPolicies, Sidecars and PeerAuthentications. Objects returned by a list
+// have no kind, it is set so the YAML can be applied again.
+func loadMeshPolicies(ctx context.Context, client kube.CLIClient, namespace string) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	add := func(object runtime.Object, gvk schema.GroupVersionKind) {
+		object.GetObjectKind().SetGroupVersionKind(gvk)
+		if objMeta, err := meta.Accessor(object); err == nil {
+			objMeta.SetManagedFields(nil)
+		}
+		objects = append(objects, object)
+	}
+
+	authorizationPolicies, err := client.Istio().SecurityV1beta1().AuthorizationPolicies(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, policy := range authorizationPolicies.Items {
+		add(policy, schema.GroupVersionKind{Group: "security.istio.io", Version: "v1beta1", Kind: "AuthorizationPolicy"})
+	}
+	sidecars, err := client.Istio().NetworkingV1().Sidecars(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, sidecar := range sidecars.Items {
+		add(sidecar, schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1", Kind: "Sidecar"})
+	}
+	peerAuthentications, err := client.Istio().SecurityV1beta1().PeerAuthentications(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, peerAuthentication := range peerAuthentications.Items {
+		add(peerAuthentication, schema.GroupVersionKind{Group: "security.istio.io", Version: "v1beta1", Kind: "PeerAuthentication"})
+	}
+	return objects, nil
+}
+
+func writeBundle(fileName string, files map[string][]byte) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name])), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openBundle extracts a capture bundle into a temporary directory, the caller removes it when done.
+func openBundle(fileName string) (*bundleManifest, string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s is not a capture bundle: %s", fileName, err)
+	}
+
+	dir, err := os.MkdirTemp("", "mesh-capture-")
+	if err != nil {
+		return nil, "", err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// never write outside of the temporary directory
+		name, ok := bundleRelativePath(header.Name)
+		if !ok {
+			os.RemoveAll(dir)
+			return nil, "", fmt.Errorf("invalid file %q in bundle", header.Name)
+		}
+		target := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, "", err
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestFile))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", errors.New("the bundle has no " + bundleManifestFile)
+	}
+	manifest := &bundleManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	if manifest.Version > bundleVersion {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("bundle version %d is newer than the supported version %d", manifest.Version, bundleVersion)
+	}
+	// the manifest is read from the bundle as well, its paths must stay inside the extracted directory
+	for _, p := range []*string{&manifest.MetricsFile, &manifest.ClustersDir} {
+		if *p == "" {
+			continue
+		}
+		name, ok := bundleRelativePath(*p)
+		if !ok {
+			os.RemoveAll(dir)
+			return nil, "", fmt.Errorf("invalid path %q in %s", *p, bundleManifestFile)
+		}
+		*p = name
+	}
+	return manifest, dir, nil
+}
+
+// bundleRelativePath cleans a path of the bundle and reports whether it stays inside the bundle.
+func bundleRelativePath(p string) (string, bool) {
+	name := filepath.Clean(filepath.FromSlash(p))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"github.com/nmnellis/mesh-helper/internal/proxyconfig"
+	"github.com/spf13/cobra"
+	"istio.io/istio/istioctl/pkg/util/configdump"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CertsArgs struct {
+	PodSelectorArgs
+	CommandTimeout time.Duration
+	Concurrency    int
+	Output         string
+	WarnWithin     time.Duration
+}
+
+const (
+	certExpired          = "EXPIRED"
+	certNearExpiry       = "NEAR_EXPIRY"
+	certIdentityMismatch = "IDENTITY_MISMATCH"
+)
+
+// certRecord is a certificate loaded by one pod's proxy.
+type certRecord struct {
+	Pod            string    `json:"pod"`
+	ServiceAccount string    `json:"service_account"`
+	Type           string    `json:"type"`
+	Identity       string    `json:"identity,omitempty"`
+	Issuer         string    `json:"issuer,omitempty"`
+	SerialNumber   string    `json:"serial_number"`
+	ValidFrom      time.Time `json:"valid_from"`
+	ExpirationTime time.Time `json:"expiration_time"`
+	Problems       []string  `json:"problems,omitempty"`
+}
+
+func certsCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
+	certsArgs := &CertsArgs{}
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Report the workload certificates and SPIFFE identities of proxies",
+		Long: `Read the certificates each selected proxy has loaded from the Envoy certs endpoint and report the SPIFFE
+identity, issuer, serial number, validity and time to expiry. Certificates that expired or expire within
+--warn-within, and workload certificates whose identity does not match the pod's ServiceAccount, are flagged.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCerts(ctx, globalFlags, certsArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	addPodSelectorFlags(cmd, &certsArgs.PodSelectorArgs)
+	cmd.Flags().DurationVarP(&certsArgs.CommandTimeout, "timeout", "t", time.Minute*5, "Timeout")
+	cmd.Flags().IntVar(&certsArgs.Concurrency, "concurrency", 10, "Number of pods to read certificates from in parallel")
+	cmd.Flags().StringVarP(&certsArgs.Output, "output", "o", "table", "Output format (table, json)")
+	cmd.Flags().DurationVar(&certsArgs.WarnWithin, "warn-within", 6*time.Hour, "Flag certificates expiring within this duration, Istio rotates 24h workload certificates after about 12h")
+	return cmd
+}
+
+func runCerts(ctx context.Context, globalFlags *GlobalFlags, args *CertsArgs) error {
+	if err := args.PodSelectorArgs.validate(); err != nil {
+		return err
+	}
+	if args.Output != "table" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+	if args.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if err := disableIstioInfoLogging(); err != nil {
+		return err
+	}
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, args.CommandTimeout)
+	defer cancel()
+
+	pods, err := findPods(ctx, client, &args.PodSelectorArgs)
+	if err != nil {
+		return err
+	}
+	var proxyPods []string
+	for _, namespacePodName := range sortedKeys(pods) {
+		if containsProxyContainer(pods[namespacePodName]) {
+			proxyPods = append(proxyPods, namespacePodName)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s does not have an istio-proxy container\n", namespacePodName)
+		}
+	}
+
+	now := time.Now()
+	var records []*certRecord
+	var mu sync.Mutex
+	podErrors := forEachPod(proxyPods, args.Concurrency, "reading certificates", func(namespacePodName string) error {
+		pod := pods[namespacePodName]
+		response, err := envoyGet(ctx, pod, client, "certs")
+		if err != nil {
+			return err
+		}
+		var certs envoy.Certs
+		if err := json.Unmarshal([]byte(response), &certs); err != nil {
+			return err
+		}
+		// the issuer is not part of the certs endpoint, it is read from the SDS secrets when available
+		issuers := map[string]string{}
+		if response, err := envoyGet(ctx, pod, client, "config_dump?resource=dynamic_active_secrets"); err == nil {
+			dump := &configdump.Wrapper{}
+			if err := dump.UnmarshalJSON([]byte(response)); err == nil {
+				secrets, _ := proxyconfig.ActiveSecrets(dump)
+				for _, secret := range secrets {
+					issuers[normalizeSerial(secret.SerialNumber)] = secret.Issuer
+				}
+			}
+		}
+
+		podRecords := buildCertRecords(namespacePodName, pod, &certs, issuers, now, args.WarnWithin)
+		mu.Lock()
+		records = append(records, podRecords...)
+		mu.Unlock()
+		return nil
+	})
+	printPodErrors(podErrors)
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Pod != records[j].Pod {
+			return records[i].Pod < records[j].Pod
+		}
+		return records[i].Type > records[j].Type
+	})
+	if args.Output == "json" {
+		if records == nil {
+			records = []*certRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printCertTable(records, now)
+	return nil
+}
+
+// buildCertRecords returns the distinct certificates of a proxy. Envoy reports the certificates once per TLS
+// context, so the same workload certificate and root are listed many times.
+func buildCertRecords(namespacePodName string, pod *corev1.Pod, certs *envoy.Certs, issuers map[string]string, now time.Time, warnWithin time.Duration) []*certRecord {
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+
+	seen := map[string]bool{}
+	var records []*certRecord
+	add := func(certType string, details envoy.CertificateDetails, leaf bool) {
+		key := certType + "/" + details.SerialNumber
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+
+		record := &certRecord{
+			Pod:            namespacePodName,
+			ServiceAccount: serviceAccount,
+			Type:           certType,
+			Identity:       details.SPIFFEID(),
+			Issuer:         issuers[normalizeSerial(details.SerialNumber)],
+			SerialNumber:   details.SerialNumber,
+			ValidFrom:      details.ValidFrom,
+			ExpirationTime: details.ExpirationTime,
+		}
+		switch {
+		case now.After(details.ExpirationTime):
+			record.Problems = append(record.Problems, certExpired)
+		case details.ExpirationTime.Sub(now) < warnWithin:
+			record.Problems = append(record.Problems, certNearExpiry)
+		}
+		if leaf && !identityMatches(record.Identity, pod.Namespace, serviceAccount) {
+			record.Problems = append(record.Problems, certIdentityMismatch)
+		}
+		records = append(records, record)
+	}
+
+	for _, chain := range certs.Certificates {
+		for i, details := range chain.CertChain {
+			add("Cert Chain", details, i == 0)
+		}
+		for _, details := range chain.CACert {
+			add("CA", details, false)
+		}
+	}
+	return records
+}
+
+// identityMatches checks a SPIFFE ID such as spiffe://cluster.local/ns/default/sa/reviews against the pod, in any
+// trust domain.
+func identityMatches(identity string, namespace string, serviceAccount string) bool {
+	return strings.HasPrefix(identity, "spiffe://") && strings.HasSuffix(identity, "/ns/"+namespace+"/sa/"+serviceAccount)
+}
+
+func normalizeSerial(serial string) string {
+	return strings.TrimLeft(strings.ToLower(serial), "0")
+}
+
+func printCertTable(records []*certRecord, now time.Time) {
+	tbl := newTable("Pod", "Type", "Identity", "Issuer", "Serial Number", "Valid From", "Expires", "Expires In", "Status")
+	var flagged int
+	for _, r := range records {
+		status := "OK"
+		if len(r.Problems) > 0 {
+			flagged++
+			status = color.RedString(strings.Join(r.Problems, ","))
+		}
+		tbl.AddRow(r.Pod, r.Type, r.Identity, r.Issuer, r.SerialNumber, r.ValidFrom.Format(time.RFC3339),
+			r.ExpirationTime.Format(time.RFC3339), formatRemaining(r.ExpirationTime.Sub(now)), status)
+	}
+	tbl.Print()
+	fmt.Printf("\n%d of %d certificate(s) flagged\n", flagged, len(records))
+}
+
+// formatRemaining shows long durations in days and short ones to the minute.
+func formatRemaining(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "expired"
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+func sortedKeys(pods map[string]*corev1.Pod) []string {
+	var names []string
+	for namespacePodName := range pods {
+		names = append(names, namespacePodName)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -17,24 +17,41 @@ import (
 	v1beta1api "istio.io/api/type/v1beta1"
 	networkingv1 "istio.io/client-go/pkg/apis/networking/v1"
 	"istio.io/client-go/pkg/apis/security/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingk8sv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
 type DependenciesArgs struct {
-	Name      string
-	File      string
-	Output    string
-	PromURL   string
-	Audit     bool
-	Metric    string
-	Namespace string
+	Name        string
+	File        string
+	Bundle      string
+	Output      string
+	PromURL     string
+	Audit       bool
+	Metric      string
+	Namespace   string
+	DiffAgainst string
+	OutputDir   string
+	Apply       bool
+	DryRun      string
+	Prune       bool
+
+	CompareFrom   string
+	CompareTo     string
+	CompareWindow time.Duration
 }
 
-func dependenciesCmd() *cobra.Command {
+func dependenciesCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
 	depArgs := &DependenciesArgs{}
 	cmd := &cobra.Command{
 		Use:     "dependencies",
@@ -42,46 +59,70 @@ func dependenciesCmd() *cobra.Command {
 		Short:   "List application dependencies",
 		Long:    ` `,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runDependencies(depArgs)
+			return runDependencies(ctx, globalFlags, depArgs)
 		},
 		CompletionOptions: cobra.CompletionOptions{
 			DisableDefaultCmd: true,
 		},
 		SilenceUsage: true,
 	}
-	cmd.Flags().StringVarP(&depArgs.Output, "output", "o", "tree", "Output Format (tree, authz, sidecar)")
+	cmd.Flags().StringVarP(&depArgs.Output, "output", "o", "tree", "Output Format (tree, authz, sidecar, networkpolicy)")
 	cmd.Flags().StringVarP(&depArgs.File, "file", "f", "", "Read from a prometheus formatted input file")
+	cmd.Flags().StringVar(&depArgs.Bundle, "bundle", "", "Read the metrics of a bundle written by mesh-helper capture")
 	cmd.Flags().StringVar(&depArgs.Name, "name", "", "Filter for workload by name")
 	cmd.Flags().StringVar(&depArgs.PromURL, "prom-url", "", "Call prometheus directly to fetch data")
 	cmd.Flags().BoolVar(&depArgs.Audit, "audit", true, "Audit traffic rather than deny")
 	cmd.Flags().StringVar(&depArgs.Metric, "metric", "istio_tcp_sent_bytes_total", "Metric to grab dependency tree (istio_tcp_sent_bytes_total, istio_requests_total)")
 	cmd.Flags().StringVarP(&depArgs.Namespace, "namespace", "n", "", "Namespace to runDependencies the command in.")
+	cmd.Flags().StringVar(&depArgs.OutputDir, "output-dir", "", "Write generated policies to <dir>/<namespace>/<kind>-<name>.yaml with a kustomization.yaml per namespace")
+	cmd.Flags().BoolVar(&depArgs.Apply, "apply", false, "Server-side apply the generated policies to the cluster")
+	cmd.Flags().StringVar(&depArgs.DryRun, "dry-run", "none", "With --apply, only validate the changes on the server (none, server)")
+	cmd.Flags().BoolVar(&depArgs.Prune, "prune", false, "With --apply, delete mesh-helper managed policies in --namespace (or the cluster) that were not generated, not allowed with --name")
+	cmd.Flags().StringVar(&depArgs.DiffAgainst, "diff-against", "", "Diff generated policies against a directory of manifests or the deployed ones (cluster)")
+	cmd.Flags().StringVar(&depArgs.CompareFrom, "compare-from", "", "Baseline of the dependency diff, a snapshot file, now, an RFC3339 time or a duration ago such as 168h")
+	cmd.Flags().StringVar(&depArgs.CompareTo, "compare-to", "", "Dependencies compared with the baseline, a snapshot file or a time like --compare-from. Exits with 1 when new edges appeared")
+	cmd.Flags().DurationVar(&depArgs.CompareWindow, "compare-window", time.Hour, "Traffic window ending at each compared time, with --prom-url")
+	cmd.MarkFlagsRequiredTogether("compare-from", "compare-to")
+	cmd.MarkFlagsMutuallyExclusive("compare-from", "file")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "file", "prom-url")
+	cmd.MarkFlagsMutuallyExclusive("diff-against", "apply", "output-dir")
 	return cmd
 }
 
-func runDependencies(args *DependenciesArgs) error {
-
-	var storage *teststorage.TestStorage
-	var err error
+func runDependencies(ctx context.Context, globalFlags *GlobalFlags, args *DependenciesArgs) error {
+	if args.DiffAgainst != "" && !generatesPolicies(args.Output) {
+		return errors.New("--diff-against requires --output authz, sidecar or networkpolicy")
+	}
+	if args.Apply && !generatesPolicies(args.Output) {
+		return errors.New("--apply requires --output authz, sidecar or networkpolicy")
+	}
+	if (args.Prune || args.DryRun != "none") && !args.Apply {
+		return errors.New("--prune and --dry-run require --apply")
+	}
+	if args.Prune && args.Name != "" {
+		// only the policies of the filtered workloads are generated, pruning would delete every other managed one
+		return errors.New("--prune can not be combined with --name")
+	}
 
-	if args.File != "" {
-		storage, err = prom.LoadStorageFromFile(args.File)
+	if args.Bundle != "" {
+		manifest, dir, err := openBundle(args.Bundle)
 		if err != nil {
 			return err
 		}
-
-	} else if args.PromURL != "" {
-		storage, err = prom.LoadStorageFromEndpoint(args.PromURL, args.Metric)
-	} else {
-		return errors.New("please specify --file or --name")
+		defer os.RemoveAll(dir)
+		if manifest.MetricsFile == "" {
+			return fmt.Errorf("bundle %s was captured without --prom-url and has no metrics", args.Bundle)
+		}
+		args.File = filepath.Join(dir, manifest.MetricsFile)
+	}
+	if args.CompareFrom != "" {
+		return compareDependencies(args)
 	}
-	// Create an engine for query evaluation
-	engine := promql.NewEngine(promql.EngineOpts{
-		Timeout:    10 * time.Second,
-		MaxSamples: 50000000,
-	})
 
-	fakeAPI := &prom.FakeAPI{Storage: storage, Engine: engine}
+	fakeAPI, err := loadPromAPI(args.File, args.PromURL, args.Metric)
+	if err != nil {
+		return err
+	}
 
 	sourceToDestMap, err := mapSourcesToDestinations(fakeAPI, args.Namespace, args.Name, args.Metric)
 	if err != nil {
@@ -93,13 +134,30 @@ func runDependencies(args *DependenciesArgs) error {
 		if err != nil {
 			return err
 		}
-	} else if args.Output == "authz" {
-		err = generateIstioAuthZPolicies(sourceToDestMap, fakeAPI, args.Namespace, args.Metric)
+	} else if generatesPolicies(args.Output) {
+		var policies []runtime.Object
+		switch args.Output {
+		case "authz":
+			policies, err = generateIstioAuthZPolicies(sourceToDestMap, fakeAPI, args.Namespace, args.Metric)
+		case "sidecar":
+			policies, err = generateIstioSidecar(sourceToDestMap, fakeAPI, args.Namespace, args.Metric)
+		case "networkpolicy":
+			policies, err = generateNetworkPolicies(sourceToDestMap, fakeAPI, args.Metric)
+		}
 		if err != nil {
 			return err
 		}
-	} else if args.Output == "sidecar" {
-		err = generateIstioSidecar(sourceToDestMap, fakeAPI, args.Namespace, args.Metric)
+
+		if args.DiffAgainst != "" {
+			return diffIstioObjects(ctx, globalFlags, policies, args)
+		}
+		if args.Apply {
+			return applyIstioObjects(ctx, globalFlags, policies, args)
+		}
+		if args.OutputDir != "" {
+			return writeIstioObjects(args.OutputDir, policies, args)
+		}
+		err = printIstioObjects(policies)
 		if err != nil {
 			return err
 		}
@@ -108,12 +166,64 @@ func runDependencies(args *DependenciesArgs) error {
 	return nil
 }
 
-func generateIstioSidecar(destMap map[string][]*domain.Metadata, api *prom.FakeAPI, namespace string, metric string) error {
+// generatesPolicies reports whether the output format produces Kubernetes objects rather than a tree.
+func generatesPolicies(output string) bool {
+	return output == "authz" || output == "sidecar" || output == "networkpolicy"
+}
+
+// loadPromAPI loads the metrics from a prometheus formatted file or a prometheus server into an in memory storage
+// that can be queried with PromQL.
+func loadPromAPI(file string, promURL string, metric string) (*prom.FakeAPI, error) {
+	return loadPromAPIWindow(file, promURL, metric, 0)
+}
+
+// loadPromAPIWindow is loadPromAPI with only the traffic of the last window when reading from a prometheus server.
+// Without a window, and for files, the counter totals are used.
+func loadPromAPIWindow(file string, promURL string, metric string, window time.Duration) (*prom.FakeAPI, error) {
+	var storage *teststorage.TestStorage
+	var err error
+
+	if file != "" {
+		if window > 0 {
+			return nil, errors.New("--window can only be used with --prom-url, a file holds the counters it was exported with")
+		}
+		storage, err = prom.LoadStorageFromFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+	} else if promURL != "" && window > 0 {
+		storage, err = prom.LoadStorageFromEndpointWindow(promURL, metric, window, time.Now())
+		if err != nil {
+			return nil, err
+		}
+	} else if promURL != "" {
+		storage, err = prom.LoadStorageFromEndpoint(promURL, metric)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("please specify --file or --prom-url")
+	}
+	return newFakeAPI(storage), nil
+}
+
+func newFakeAPI(storage *teststorage.TestStorage) *prom.FakeAPI {
+	// Create an engine for query evaluation
+	engine := promql.NewEngine(promql.EngineOpts{
+		Timeout:    10 * time.Second,
+		MaxSamples: 50000000,
+	})
+
+	return &prom.FakeAPI{Storage: storage, Engine: engine}
+}
+
+func generateIstioSidecar(destMap map[string][]*domain.Metadata, api *prom.FakeAPI, namespace string, metric string) ([]runtime.Object, error) {
 	var policies []runtime.Object
 	for source, destinations := range destMap {
 		_, sourcesByName, err := queryAllWorkloads(api, namespace, source, metric)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var destinationWorkloads []string
 		for _, destination := range destinations {
@@ -124,11 +234,12 @@ func generateIstioSidecar(destMap map[string][]*domain.Metadata, api *prom.FakeA
 		policy := &networkingv1.Sidecar{
 			TypeMeta: metav1.TypeMeta{
 				Kind:       "Sidecar",
-				APIVersion: "security.istio.io/v1beta1",
+				APIVersion: "networking.istio.io/v1",
 			},
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      source,
 				Namespace: string(sourceMetrics.Metric["source_workload_namespace"]),
+				Labels:    managedLabels,
 			},
 			Spec: v2.Sidecar{
 				Egress: []*v2.IstioEgressListener{
@@ -147,19 +258,15 @@ func generateIstioSidecar(destMap map[string][]*domain.Metadata, api *prom.FakeA
 		policies = append(policies, policy)
 	}
 
-	err := printIstioObjects(policies)
-	if err != nil {
-		return err
-	}
-	return nil
+	return policies, nil
 }
 
-func generateIstioAuthZPolicies(destMap map[string][]*domain.Metadata, api *prom.FakeAPI, namespace string, metric string) error {
+func generateIstioAuthZPolicies(destMap map[string][]*domain.Metadata, api *prom.FakeAPI, namespace string, metric string) ([]runtime.Object, error) {
 	var policies []runtime.Object
 	for source, destinations := range destMap {
 		_, sourcesByName, err := queryAllWorkloads(api, namespace, source, metric)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		var destinationPrinciples []string
 		for _, destination := range destinations {
@@ -175,6 +282,7 @@ func generateIstioAuthZPolicies(destMap map[string][]*domain.Metadata, api *prom
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      source,
 				Namespace: string(sourceMetrics.Metric["source_workload_namespace"]),
+				Labels:    managedLabels,
 			},
 			Spec: securityv1beta1.AuthorizationPolicy{
 				Selector: &v1beta1api.WorkloadSelector{
@@ -197,33 +305,356 @@ func generateIstioAuthZPolicies(destMap map[string][]*domain.Metadata, api *prom
 		policies = append(policies, policy)
 	}
 
-	err := printIstioObjects(policies)
+	return policies, nil
+}
+
+// meshInboundPorts are allowed next to the observed application ports: HBONE (15008), used by ztunnel and waypoints
+// in ambient mode, and the sidecar inbound capture port (15006).
+var meshInboundPorts = []string{"15008", "15006"}
+
+// generateNetworkPolicies turns the observed dependencies into NetworkPolicies, one per destination workload, that
+// only admit ingress from the pods of the workloads calling it. The --namespace and --name filters select the
+// destinations, the callers and ports of a destination are taken from all of its traffic so none are cut off. The
+// ports are restricted when the metrics carry a destination_port label, the mesh inbound ports stay open.
+func generateNetworkPolicies(destMap map[string][]*domain.Metadata, api *prom.FakeAPI, metric string) ([]runtime.Object, error) {
+	ports, err := queryDestinationPorts(api, "", metric)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	type peer struct {
+		name      string
+		namespace string
+	}
+	destinations := map[peer]bool{}
+	for _, dests := range destMap {
+		for _, destination := range dests {
+			// external ServiceEntry hosts are reported as workloads named after the host
+			if destination.Name == "unknown" || strings.Contains(destination.Name, ".") ||
+				destination.Namespace == "" || destination.Namespace == "unknown" {
+				continue
+			}
+			destinations[peer{name: destination.Name, namespace: destination.Namespace}] = true
+		}
+	}
+
+	_, samplesBySource, err := queryAllWorkloads(api, "", "", metric)
+	if err != nil {
+		return nil, err
+	}
+	callersByDestination := map[peer][]peer{}
+	var unknownCallers = map[peer]bool{}
+	var sources []string
+	for source := range samplesBySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		for _, sample := range samplesBySource[source] {
+			dest := peer{
+				name:      string(sample.Metric["destination_workload"]),
+				namespace: string(sample.Metric["destination_workload_namespace"]),
+			}
+			if !destinations[dest] {
+				continue
+			}
+			sourceNamespace := string(sample.Metric["source_workload_namespace"])
+			if source == "unknown" || sourceNamespace == "" || sourceNamespace == "unknown" {
+				unknownCallers[dest] = true
+				continue
+			}
+			callersByDestination[dest] = append(callersByDestination[dest], peer{name: source, namespace: sourceNamespace})
+		}
+	}
+
+	var sortedDestinations []peer
+	for destination := range callersByDestination {
+		sortedDestinations = append(sortedDestinations, destination)
+	}
+	sort.Slice(sortedDestinations, func(i, j int) bool {
+		if sortedDestinations[i].namespace != sortedDestinations[j].namespace {
+			return sortedDestinations[i].namespace < sortedDestinations[j].namespace
+		}
+		return sortedDestinations[i].name < sortedDestinations[j].name
+	})
+
+	var policies []runtime.Object
+	for _, destination := range sortedDestinations {
+		callers := callersByDestination[destination]
+		if unknownCallers[destination] {
+			// a policy would cut off the callers that can not be expressed with selectors
+			fmt.Fprintf(os.Stderr, "skipping NetworkPolicy for %s/%s, it is called by unknown workloads\n", destination.namespace, destination.name)
+			continue
+		}
+		var from []networkingk8sv1.NetworkPolicyPeer
+		var destinationPorts []networkingk8sv1.NetworkPolicyPort
+		seenPeers := map[peer]bool{}
+		seenPorts := map[string]bool{}
+		for _, caller := range callers {
+			if !seenPeers[caller] {
+				seenPeers[caller] = true
+				from = append(from, networkingk8sv1.NetworkPolicyPeer{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"kubernetes.io/metadata.name": caller.namespace},
+					},
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": caller.name},
+					},
+				})
+			}
+			for _, port := range ports[caller.name+"|"+destination.name+"|"+destination.namespace] {
+				if seenPorts[port] {
+					continue
+				}
+				seenPorts[port] = true
+				portValue := intstr.Parse(port)
+				protocol := corev1.ProtocolTCP
+				destinationPorts = append(destinationPorts, networkingk8sv1.NetworkPolicyPort{
+					Protocol: &protocol,
+					Port:     &portValue,
+				})
+			}
+		}
+
+		if len(destinationPorts) > 0 {
+			// mesh traffic reaches the pod on Istio's ports rather than the application port in ambient mode
+			for _, port := range meshInboundPorts {
+				if seenPorts[port] {
+					continue
+				}
+				portValue := intstr.Parse(port)
+				protocol := corev1.ProtocolTCP
+				destinationPorts = append(destinationPorts, networkingk8sv1.NetworkPolicyPort{
+					Protocol: &protocol,
+					Port:     &portValue,
+				})
+			}
+		}
+
+		policy := &networkingk8sv1.NetworkPolicy{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "NetworkPolicy",
+				APIVersion: "networking.k8s.io/v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      destination.name,
+				Namespace: destination.namespace,
+				Labels:    managedLabels,
+			},
+			Spec: networkingk8sv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{
+						"app": destination.name,
+					},
+				},
+				Ingress: []networkingk8sv1.NetworkPolicyIngressRule{{
+					From:  from,
+					Ports: destinationPorts,
+				}},
+				PolicyTypes: []networkingk8sv1.PolicyType{networkingk8sv1.PolicyTypeIngress},
+			},
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// queryDestinationPorts returns the observed destination ports keyed by source|destination|destination namespace.
+// Istio does not report the port by default, so the map is empty unless the metrics carry a destination_port label.
+func queryDestinationPorts(api *prom.FakeAPI, namespace string, metric string) (map[string][]string, error) {
+	var query string
+	if namespace != "" {
+		query = fmt.Sprintf("sum(%s{source_workload_namespace=\"%s\"}) by (source_workload,destination_workload,destination_workload_namespace,destination_port)", metric, namespace)
+	} else {
+		query = fmt.Sprintf("sum(%s) by (source_workload,destination_workload,destination_workload_namespace,destination_port)", metric)
+	}
+	output, _, err := api.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	ports := map[string][]string{}
+	if vector, ok := output.(model.Vector); ok {
+		for _, sample := range vector {
+			port := string(sample.Metric["destination_port"])
+			if port == "" {
+				continue
+			}
+			key := string(sample.Metric["source_workload"]) + "|" + string(sample.Metric["destination_workload"]) + "|" + string(sample.Metric["destination_workload_namespace"])
+			ports[key] = append(ports[key], port)
+		}
+	}
+	return ports, nil
 }
 
 func printIstioObjects(policies []runtime.Object) error {
-	scheme := runtime.NewScheme()
-	serializer := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{Yaml: true, Pretty: true, Strict: true})
+	serializer := newYAMLSerializer()
 
 	for _, policy := range policies {
-		// Encode the policy to JSON
-		yamlData, err := runtime.Encode(serializer, policy)
+		output, err := encodeIstioObject(serializer, policy)
 		if err != nil {
-			fmt.Printf("Error encoding to YAML: %v\n", err)
 			return err
 		}
-		output := strings.ReplaceAll(string(yamlData), "status: {}\n", "")
-		output = strings.ReplaceAll(output, "  creationTimestamp: null\n", "")
 		fmt.Println(output)
 		fmt.Println("---")
 	}
 	return nil
 }
 
+// writeIstioObjects writes one file per object and a kustomization.yaml per namespace listing the files mesh-helper
+// wrote. Files of the generated kind left over from earlier runs belong to workloads that are no longer observed
+// and are removed from the namespaces in scope: the one of --namespace, or all of them without it. Nothing is
+// removed when --name only generated part of the objects.
+func writeIstioObjects(dir string, policies []runtime.Object, args *DependenciesArgs) error {
+	serializer := newYAMLSerializer()
+
+	written := map[string]map[string]bool{}
+	for _, policy := range policies {
+		objMeta, err := meta.Accessor(policy)
+		if err != nil {
+			return err
+		}
+		namespace := objMeta.GetNamespace()
+		if namespace == "" {
+			namespace = "default"
+		}
+		namespaceDir := filepath.Join(dir, namespace)
+		if err := os.MkdirAll(namespaceDir, 0o755); err != nil {
+			return err
+		}
+
+		output, err := encodeIstioObject(serializer, policy)
+		if err != nil {
+			return err
+		}
+		kind := strings.ToLower(policy.GetObjectKind().GroupVersionKind().Kind)
+		fileName := fmt.Sprintf("%s-%s.yaml", kind, objMeta.GetName())
+		if err := os.WriteFile(filepath.Join(namespaceDir, fileName), []byte(output), 0o644); err != nil {
+			return err
+		}
+		if written[namespaceDir] == nil {
+			written[namespaceDir] = map[string]bool{}
+		}
+		written[namespaceDir][fileName] = true
+	}
+
+	namespaceDirs, err := outputNamespaceDirs(dir, args.Namespace, written)
+	if err != nil {
+		return err
+	}
+	kind := strings.ToLower(kindForOutput(args.Output))
+	for _, namespaceDir := range namespaceDirs {
+		// objects generated for destinations in other namespaces do not cover everything generated there
+		inScope := args.Namespace == "" || namespaceDir == filepath.Join(dir, args.Namespace)
+		if err := writeKustomization(namespaceDir, kind, written[namespaceDir], inScope && args.Name == ""); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote %d object(s) to %d namespace(s) in %s\n", len(policies), len(written), dir)
+	return nil
+}
+
+// outputNamespaceDirs returns the namespace directories in scope of this run: the one of --namespace, or every
+// directory under dir, including the ones of namespaces no longer observed.
+func outputNamespaceDirs(dir string, namespace string, written map[string]map[string]bool) ([]string, error) {
+	dirs := map[string]bool{}
+	for namespaceDir := range written {
+		dirs[namespaceDir] = true
+	}
+	if namespace != "" {
+		dirs[filepath.Join(dir, namespace)] = true
+	} else {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs[filepath.Join(dir, entry.Name())] = true
+			}
+		}
+	}
+	var sorted []string
+	for namespaceDir := range dirs {
+		sorted = append(sorted, namespaceDir)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// writeKustomization lists the files written in this run and the files of other kinds written by earlier runs with
+// a different --output. Stale files of the generated kind are removed when removeStale is set, other files are
+// left alone and reported as not referenced.
+func writeKustomization(dir string, kind string, written map[string]bool, removeStale bool) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var resources []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == "kustomization.yaml" || filepath.Ext(name) != ".yaml" {
+			continue
+		}
+		switch {
+		case written[name]:
+			resources = append(resources, name)
+		case strings.HasPrefix(name, kind+"-") && removeStale:
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+			fmt.Printf("removed stale %s\n", filepath.Join(dir, name))
+		case generatedFileKind(name) != "":
+			resources = append(resources, name)
+		default:
+			fmt.Fprintf(os.Stderr, "warning: %s was not written by mesh-helper and is not in the kustomization\n", filepath.Join(dir, name))
+		}
+	}
+
+	kustomizationFile := filepath.Join(dir, "kustomization.yaml")
+	if len(resources) == 0 {
+		if err := os.Remove(kustomizationFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	kustomization := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n"
+	for _, resource := range resources {
+		kustomization += fmt.Sprintf("- %s\n", resource)
+	}
+	return os.WriteFile(kustomizationFile, []byte(kustomization), 0o644)
+}
+
+// generatedFileKind returns the kind of a <kind>-<name>.yaml file written by mesh-helper, or an empty string.
+func generatedFileKind(name string) string {
+	for kind := range resourcesByKind {
+		if strings.HasPrefix(name, strings.ToLower(kind)+"-") {
+			return kind
+		}
+	}
+	return ""
+}
+
+func newYAMLSerializer() *json.Serializer {
+	scheme := runtime.NewScheme()
+	return json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{Yaml: true, Pretty: true, Strict: true})
+}
+
+func encodeIstioObject(serializer *json.Serializer, policy runtime.Object) (string, error) {
+	// Encode the policy to JSON
+	yamlData, err := runtime.Encode(serializer, policy)
+	if err != nil {
+		fmt.Printf("Error encoding to YAML: %v\n", err)
+		return "", err
+	}
+	output := strings.ReplaceAll(string(yamlData), "status: {}\n", "")
+	output = strings.ReplaceAll(output, "  creationTimestamp: null\n", "")
+	return output, nil
+}
+
 func generateAndPrintTree(fakeAPI *prom.FakeAPI, sourceToDestMap map[string][]*domain.Metadata, args *DependenciesArgs) error {
 	rootWorkloads, err := findRootWorkloads(fakeAPI, args.Namespace, args.Name, args.Metric)
 	if err != nil {
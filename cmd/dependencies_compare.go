@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"os"
+	"strings"
+	"time"
+)
+
+// compareDependencies builds the dependency graph twice, from snapshot files or from the traffic of the window
+// ending at two points in time, and prints the edges and workloads that appeared or disappeared. New edges are
+// returned as an error so the command exits with 1.
+func compareDependencies(args *DependenciesArgs) error {
+	if args.Output != "tree" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q with --compare-from and --compare-to, use tree or json", args.Output)
+	}
+	now := time.Now()
+	from, err := loadCompareGraph(args.CompareFrom, args, now)
+	if err != nil {
+		return fmt.Errorf("--compare-from: %w", err)
+	}
+	to, err := loadCompareGraph(args.CompareTo, args, now)
+	if err != nil {
+		return fmt.Errorf("--compare-to: %w", err)
+	}
+
+	diff := domain.CompareGraphs(from, to)
+	if args.Output == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		printGraphDiff(diff)
+	}
+	if len(diff.AddedEdges) > 0 {
+		return fmt.Errorf("%d new edge(s) appeared", len(diff.AddedEdges))
+	}
+	return nil
+}
+
+// loadCompareGraph reads a snapshot file, or queries prometheus for the traffic of the window ending at a time.
+func loadCompareGraph(value string, args *DependenciesArgs, now time.Time) (*domain.Graph, error) {
+	var api *prom.FakeAPI
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		api, err = loadPromAPI(value, "", args.Metric)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if args.PromURL == "" {
+			return nil, fmt.Errorf("%q is not a file, comparing points in time requires --prom-url", value)
+		}
+		end, err := parseCompareTime(value, now)
+		if err != nil {
+			return nil, err
+		}
+		storage, err := prom.LoadStorageFromEndpointWindow(args.PromURL, args.Metric, args.CompareWindow, end)
+		if err != nil {
+			return nil, err
+		}
+		api = newFakeAPI(storage)
+	}
+
+	graph, err := loadDependencyGraph(api, args.Metric)
+	if err != nil {
+		return nil, err
+	}
+	return filterGraph(graph, args.Namespace, args.Name), nil
+}
+
+// parseCompareTime accepts now, an RFC3339 time or how long ago, e.g. 24h.
+func parseCompareTime(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	return time.Time{}, errors.New("use a snapshot file, now, an RFC3339 time or a duration ago such as 24h")
+}
+
+// filterGraph keeps the edges touching the namespace, and the ones from or to workloads starting with the name.
+func filterGraph(graph *domain.Graph, namespace string, name string) *domain.Graph {
+	if namespace == "" && name == "" {
+		return graph
+	}
+	var edges []*domain.Edge
+	for _, e := range graph.Edges {
+		if namespace != "" && e.Source.Namespace != namespace && e.Destination.Namespace != namespace {
+			continue
+		}
+		if name != "" && !strings.HasPrefix(e.Source.Name, name) && !strings.HasPrefix(e.Destination.Name, name) {
+			continue
+		}
+		edges = append(edges, e)
+	}
+	return domain.NewGraph(edges)
+}
+
+func printGraphDiff(diff *domain.GraphDiff) {
+	if len(diff.AddedEdges)+len(diff.RemovedEdges)+len(diff.AddedWorkloads)+len(diff.RemovedWorkloads) == 0 {
+		fmt.Println("No dependency changes")
+		return
+	}
+	printEdges := func(title string, edges []*domain.Edge, attribute color.Attribute) {
+		if len(edges) == 0 {
+			return
+		}
+		fmt.Printf("%s (%d)\n", title, len(edges))
+		tbl := newTable("Source", "Destination", "Traffic")
+		for _, e := range edges {
+			tbl.AddRow(colorRow([]interface{}{e.Source.String(), e.Destination.String(), formatValue(e.Value)}, attribute)...)
+		}
+		tbl.Print()
+		fmt.Println()
+	}
+	printEdges("Added edges", diff.AddedEdges, color.FgGreen)
+	printEdges("Removed edges", diff.RemovedEdges, color.FgRed)
+
+	for _, w := range diff.AddedWorkloads {
+		fmt.Println(color.GreenString("+ " + w.String()))
+	}
+	for _, w := range diff.RemovedWorkloads {
+		fmt.Println(color.RedString("- " + w.String()))
+	}
+	fmt.Printf("\n%d edge(s) added, %d removed, %d workload(s) added, %d removed\n",
+		len(diff.AddedEdges), len(diff.RemovedEdges), len(diff.AddedWorkloads), len(diff.RemovedWorkloads))
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/diff"
+	"github.com/nmnellis/mesh-helper/internal/manifest"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// diffAgainstCluster is the --diff-against value that loads the deployed policies from the cluster.
+const diffAgainstCluster = "cluster"
+
+func diffIstioObjects(ctx context.Context, globalFlags *GlobalFlags, generated []runtime.Object, args *DependenciesArgs) error {
+	var existing []runtime.Object
+	var err error
+	if args.DiffAgainst == diffAgainstCluster {
+		existing, err = loadDeployedIstioObjects(ctx, globalFlags, args.Namespace, args.Output)
+	} else {
+		existing, err = manifest.LoadFromPath(args.DiffAgainst)
+		existing = filterObjects(existing, args.Namespace, args.Output)
+	}
+	if err != nil {
+		return err
+	}
+
+	printWorkloadDiffs(diff.Compare(generated, existing))
+	return nil
+}
+
+// filterObjects keeps the objects of the generated output kind, in the namespace if one is given.
+func filterObjects(objects []runtime.Object, namespace string, output string) []runtime.Object {
+	var filtered []runtime.Object
+	for _, obj := range objects {
+		if obj.GetObjectKind().GroupVersionKind().Kind != kindForOutput(output) {
+			continue
+		}
+		objMeta, err := meta.Accessor(obj)
+		if err != nil || (namespace != "" && objMeta.GetNamespace() != namespace) {
+			continue
+		}
+		filtered = append(filtered, obj)
+	}
+	return filtered
+}
+
+func loadDeployedIstioObjects(ctx context.Context, globalFlags *GlobalFlags, namespace string, output string) ([]runtime.Object, error) {
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	// only compare with the objects mesh-helper generated, hand-written policies are not ours to remove
+	listOptions := metav1.ListOptions{LabelSelector: managedByLabel + "=" + fieldManager}
+	var objects []runtime.Object
+	switch output {
+	case "sidecar":
+		sidecars, err := client.Istio().NetworkingV1().Sidecars(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, sidecar := range sidecars.Items {
+			objects = append(objects, sidecar)
+		}
+	case "networkpolicy":
+		networkPolicies, err := client.Kube().NetworkingV1().NetworkPolicies(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+		for i := range networkPolicies.Items {
+			objects = append(objects, &networkPolicies.Items[i])
+		}
+	default:
+		policies, err := client.Istio().SecurityV1beta1().AuthorizationPolicies(namespace).List(ctx, listOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, policy := range policies.Items {
+			objects = append(objects, policy)
+		}
+	}
+	return objects, nil
+}
+
+func printWorkloadDiffs(diffs []*diff.WorkloadDiff) {
+	added := color.New(color.FgGreen).SprintfFunc()
+	removed := color.New(color.FgRed).SprintfFunc()
+
+	var changed int
+	for _, d := range diffs {
+		if !d.Changed() {
+			continue
+		}
+		changed++
+		status := ""
+		if d.New {
+			status = " (new)"
+		} else if d.Stale {
+			status = " (no longer observed)"
+		}
+		fmt.Printf("%s %s/%s%s\n", d.Kind, d.Namespace, d.Name, status)
+		for _, value := range d.Added {
+			fmt.Println(added("  + %s", value))
+		}
+		for _, value := range d.Removed {
+			fmt.Println(removed("  - %s", value))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d of %d policies changed\n", changed, len(diffs))
+}
@@ -3,29 +3,38 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/fatih/color"
 	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
-	"github.com/rodaine/table"
 	"github.com/spf13/cobra"
+	"io/fs"
 	"istio.io/istio/pkg/kube"
 	"istio.io/istio/pkg/log"
 	"istio.io/istio/tools/bug-report/pkg/common"
-	"istio.io/istio/tools/bug-report/pkg/kubeclient"
-	"istio.io/istio/tools/bug-report/pkg/kubectlcmd"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"strconv"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 type EndpointsArgs struct {
+	PodSelectorArgs
 	CommandTimeout time.Duration
-	DeploymentName string
-	PodName        string
-	Namespace      string
+	Concurrency    int
+	Output         string
+	Direction      string
+	Cluster        string
+	IncludeIdle    bool
+	Breakers       bool
+	Verify         bool
+	LocalityReport bool
+	File           string
+	Bundle         string
+	Watch          bool
+	Interval       time.Duration
 }
 
 func endpointsCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
@@ -47,15 +56,61 @@ func endpointsCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command
 	// set global CLI flags
 	globalFlags.AddToFlags(cmd.PersistentFlags())
 	cmd.Flags().DurationVarP(&endpointArgs.CommandTimeout, "timeout", "t", time.Minute*5, "Timeout")
-	cmd.Flags().StringVar(&endpointArgs.PodName, "pod-name", "", "Name of pod to gather endpoints")
-	cmd.Flags().StringVarP(&endpointArgs.DeploymentName, "deployment-name", "d", "", "Name of deployment to gather endpoints from all pods")
-	cmd.Flags().StringVarP(&endpointArgs.Namespace, "namespace", "n", "", "Namespace to runDependencies the command in.")
+	addPodSelectorFlags(cmd, &endpointArgs.PodSelectorArgs)
+	cmd.Flags().StringVar(&endpointArgs.Gateway, "gateway", "", "Namespace/name of an ingress or egress gateway, its clusters are grouped by the VirtualService or HTTPRoute hosts routing to them")
+	cmd.Flags().StringVarP(&endpointArgs.Output, "output", "o", "table", "Output format (table, wide, json, yaml, csv)")
+	cmd.Flags().StringVar(&endpointArgs.Direction, "direction", "outbound", "Cluster direction to show (inbound, outbound, all)")
+	cmd.Flags().StringVar(&endpointArgs.Cluster, "cluster", "", "Glob matched against the FQDN, subset or port of the cluster name, e.g. 'reviews.*'")
+	cmd.Flags().BoolVar(&endpointArgs.IncludeIdle, "include-idle", false, "Include hosts without any traffic")
+	cmd.Flags().BoolVar(&endpointArgs.Breakers, "breakers", false, "Show circuit breaker thresholds, active connections and requests, and ejected hosts per cluster")
+	cmd.Flags().BoolVar(&endpointArgs.Verify, "verify", false, "Compare the outbound hosts with the Service EndpointSlices and report stale and missing addresses")
+	cmd.Flags().BoolVar(&endpointArgs.LocalityReport, "locality-report", false, "Group the outbound requests of each pod by destination zone and report the cross-zone share")
+	cmd.Flags().StringVarP(&endpointArgs.File, "file", "f", "", "Read saved Envoy clusters JSON from a file, or a directory of <namespace>/<pod>.json dumps, instead of a cluster")
+	cmd.Flags().StringVar(&endpointArgs.Bundle, "bundle", "", "Read the Envoy clusters JSON of a bundle written by mesh-helper capture")
+	cmd.Flags().BoolVarP(&endpointArgs.Watch, "watch", "w", false, "Poll the pods and redraw the counter deltas and rates in place until interrupted")
+	cmd.Flags().DurationVar(&endpointArgs.Interval, "interval", 5*time.Second, "Time between polls with --watch")
+	cmd.Flags().IntVar(&endpointArgs.Concurrency, "concurrency", 10, "Number of pods to collect endpoints from in parallel")
+
+	cmd.MarkFlagsMutuallyExclusive("verify", "locality-report", "breakers", "watch")
+	cmd.MarkFlagsMutuallyExclusive("verify", "file", "bundle")
+	cmd.MarkFlagsMutuallyExclusive("locality-report", "file", "bundle")
+	cmd.MarkFlagsMutuallyExclusive("watch", "bundle")
+	cmd.MarkFlagsMutuallyExclusive("gateway", "pod-name", "deployment-name", "statefulset", "daemonset", "rollout", "service", "selector", "all-namespaces")
 
-	cmd.MarkFlagRequired("namespace")
 	return cmd
 }
 
 func runEndpointsCMD(ctx context.Context, globalFlags *GlobalFlags, args *EndpointsArgs) error {
+	if err := validateEndpointFilters(args); err != nil {
+		return err
+	}
+	if args.Bundle != "" {
+		manifest, dir, err := openBundle(args.Bundle)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		args.File = filepath.Join(dir, manifest.ClustersDir)
+	}
+	if args.File != "" {
+		if args.Watch {
+			return errors.New("--watch can not be used with --file")
+		}
+		endpointInfo, err := loadEndpointInfoFromPath(args.File)
+		if err != nil {
+			return err
+		}
+		return printEndpoints(endpointInfo, args)
+	}
+	if args.Gateway != "" {
+		args.Namespace, _ = parseGatewayFlag(&args.PodSelectorArgs)
+		if args.Namespace == "" {
+			return errors.New("please specify the namespace of the gateway, e.g. --gateway istio-system/istio-ingressgateway")
+		}
+	}
+	if err := args.PodSelectorArgs.validate(); err != nil {
+		return err
+	}
 
 	// this disables Istio from printing its info logs
 	err := disableIstioInfoLogging()
@@ -63,146 +118,179 @@ func runEndpointsCMD(ctx context.Context, globalFlags *GlobalFlags, args *Endpoi
 		return err
 	}
 
-	restConfig, clientset, err := kubeclient.New(globalFlags.KubeConfigPath, globalFlags.KubeContext)
-	if err != nil {
-		return fmt.Errorf("could not initialize k8s client: %s ", err)
-	}
-	client, err := kube.NewCLIClient(kube.NewClientConfigForRestConfig(restConfig))
+	client, err := newCLIClient(globalFlags)
 	if err != nil {
 		return err
 	}
 
+	if args.Watch {
+		return watchEndpoints(ctx, client, args)
+	}
+
 	clusterResourcesCtx, getClusterResourcesCancel := context.WithTimeout(ctx, args.CommandTimeout)
 	curTime := time.Now()
 	defer func() {
 		if time.Until(curTime.Add(args.CommandTimeout)) < 0 {
-			message := "Timeout when running bug report command, please using --include or --exclude to filter"
+			message := "Timeout when collecting endpoints, please use --pod-name, --deployment-name or --selector to filter"
 			common.LogAndPrintf("%s", message)
 		}
 		getClusterResourcesCancel()
 	}()
 
 	// first find the pods associated with the query
-	pods, err := findPods(clusterResourcesCtx, clientset, args)
+	pods, err := findPods(clusterResourcesCtx, client, &args.PodSelectorArgs)
 	if err != nil {
 		return err
 	}
 
-	endpointInfo, err := getEndpointInformation(pods, client)
+	endpointInfo, err := getEndpointInformation(clusterResourcesCtx, pods, client, args.Concurrency)
 	if err != nil {
 		return err
 	}
 
-	if err := printEndpointInfo(endpointInfo); err != nil {
-		return err
+	if args.Verify {
+		return verifyEndpoints(clusterResourcesCtx, client, endpointInfo, args)
+	}
+	if args.LocalityReport {
+		return printLocalityReport(clusterResourcesCtx, client, pods, endpointInfo, args)
+	}
+	if args.Gateway != "" && !args.Breakers {
+		namespace, name := parseGatewayFlag(&args.PodSelectorArgs)
+		routes, err := gatewayRouteHosts(clusterResourcesCtx, client, pods, namespace, name)
+		if err != nil {
+			return err
+		}
+		return printGatewayEndpointInfo(endpointInfo, routes, args)
 	}
 
-	return nil
+	return printEndpoints(endpointInfo, args)
 }
 
-func disableIstioInfoLogging() error {
-	logOpts := log.DefaultOptions()
-	logOpts.SetDefaultOutputLevel("default", log.ErrorLevel)
-	err := log.Configure(logOpts)
-	if err != nil {
-		return err
+func printEndpoints(endpointInfo map[string]*envoy.Clusters, args *EndpointsArgs) error {
+	if args.Breakers {
+		return printBreakerInfo(endpointInfo, args)
 	}
-	return nil
+	return printEndpointInfo(endpointInfo, args)
 }
 
-func printEndpointInfo(clusters map[string]*envoy.Clusters) error {
-
-	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
-	columnFmt := color.New(color.FgYellow).SprintfFunc()
-
-	var noEndpointsPods []string
-	for namespacePodName, cluster := range clusters {
-
-		tbl := table.New("Cluster", "Endpoint", "Port", "Rq Success", "Rq Error", "Cx Active", "Cx Connect Fail", "Priority", "Locality")
-		tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt)
-		var rows = 0
-		for _, s := range cluster.ClusterStatuses {
-			nameSplit := strings.Split(s.Name, "|")
-			if nameSplit[0] == "outbound" {
-				for _, hs := range s.HostStatuses {
-					statsMap := map[string]string{}
-					for _, stat := range hs.Stats {
-						if stat.Value != "" {
-							value, err := strconv.Atoi(stat.Value)
-							if err != nil {
-								// just print the string
-								if stat.Value != "" {
-									//fmt.Printf("\t\t%s: %s\n", stat.Name, stat.Value)
-									statsMap[stat.Name] = stat.Value
-								}
-							} else {
-								if value != 0 {
-									//fmt.Printf("\t\t%s: %s\n", stat.Name, stat.Value)
-									statsMap[stat.Name] = stat.Value
-								}
-							}
-						}
-					}
-					if len(statsMap) > 0 {
-						var locality string
-						if hs.Locality.Region != "" {
-							locality = hs.Locality.Region
-						}
-						if hs.Locality.Zone != "" {
-							locality += "/" + hs.Locality.Zone
-						}
-						if hs.Locality.SubZone != "" {
-							locality += "/" + hs.Locality.SubZone
-						}
-
-						tbl.AddRow(nameSplit[3], hs.Address.SocketAddress.Address, hs.Address.SocketAddress.PortValue, statsMap["rq_success"], statsMap["rq_error"], statsMap["cx_active"], statsMap["cx_connect_fail"], statsMap["priority"], locality)
-						rows++
-					}
-				}
+// loadEndpointInfoFromPath reads Envoy clusters JSON saved from the admin API. A single file is keyed by its name,
+// the files of a directory by their path relative to it, e.g. default/productpage-v1-5c5fb9b4b4-f47bg.json.
+func loadEndpointInfoFromPath(path string) (map[string]*envoy.Clusters, error) {
+	podEndpoints := map[string]*envoy.Clusters{}
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(file) != ".json" {
+			return nil
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		clusters, err := parseStatsIntoEndpointInfo(string(data))
+		if err != nil {
+			return fmt.Errorf("could not parse %s: %w", file, err)
+		}
 
+		name := filepath.Base(file)
+		if file != path {
+			name, err = filepath.Rel(path, file)
+			if err != nil {
+				return err
 			}
 		}
-		if rows > 0 {
-			fmt.Printf("%s\n", namespacePodName)
-			tbl.Print()
-			fmt.Println("\n")
-		} else {
-			noEndpointsPods = append(noEndpointsPods, namespacePodName)
-		}
-	}
-	for _, pod := range noEndpointsPods {
-		fmt.Printf("\nNo outbound active endpoints found for %s", pod)
+		podEndpoints[filepath.ToSlash(strings.TrimSuffix(name, ".json"))] = clusters
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	fmt.Fprintln(os.Stderr, "found", len(podEndpoints), "pod(s)")
+	return podEndpoints, nil
+}
 
+func disableIstioInfoLogging() error {
+	logOpts := log.DefaultOptions()
+	logOpts.SetDefaultOutputLevel("default", log.ErrorLevel)
+	err := log.Configure(logOpts)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-func getEndpointInformation(pods map[string]*corev1.Pod, client kube.CLIClient) (map[string]*envoy.Clusters, error) {
+// getEndpointInformation collects the Envoy clusters of every pod with a bounded number of workers, then the
+// endpoints of ambient pods from ztunnel and their waypoints. Pods that fail are reported together once all pods
+// were collected.
+func getEndpointInformation(ctx context.Context, pods map[string]*corev1.Pod, client kube.CLIClient, concurrency int) (map[string]*envoy.Clusters, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("--concurrency must be at least 1")
+	}
 	podEndpoints := map[string]*envoy.Clusters{}
 
-	for namespacePodName, pod := range pods {
+	var proxyPods, noProxyPods []string
+	var ambientPods []*corev1.Pod
+	for _, namespacePodName := range sortedKeys(pods) {
 		// find if istio-proxy container
-		if !containsProxyContainer(pod) {
-			fmt.Printf("%s does not have an istio-proxy container\n", namespacePodName)
-			continue
-		}
-		// kubectl exec to endpoint to get stats
-		stats, err := getEndpointsFromPod(pod, client)
-		if err != nil {
-			fmt.Printf("Error getting endpoints from pod: %s %s\n", namespacePodName, err)
+		if containsProxyContainer(pods[namespacePodName]) {
+			proxyPods = append(proxyPods, namespacePodName)
+		} else if isAmbientPod(pods[namespacePodName]) {
+			ambientPods = append(ambientPods, pods[namespacePodName])
+		} else {
+			noProxyPods = append(noProxyPods, namespacePodName)
 		}
+	}
 
-		clusters, err := parseStatsIntoEndpointInfo(stats)
+	var mu sync.Mutex
+	podErrors := forEachPod(proxyPods, concurrency, "collecting endpoints", func(namespacePodName string) error {
+		clusters, err := getClustersFromPod(ctx, pods[namespacePodName], client)
 		if err != nil {
-			fmt.Printf("Error getting endpoints from pod: %s %s\n", namespacePodName, err)
+			return err
 		}
-
+		mu.Lock()
 		podEndpoints[namespacePodName] = clusters
+		mu.Unlock()
+		return nil
+	})
+	if len(ambientPods) > 0 {
+		getAmbientEndpointInformation(ctx, ambientPods, client, concurrency, podEndpoints, podErrors)
+	}
+
+	for _, namespacePodName := range noProxyPods {
+		fmt.Fprintf(os.Stderr, "%s does not have an istio-proxy container and is not in ambient mode\n", namespacePodName)
 	}
+	printPodErrors(podErrors)
 
 	return podEndpoints, nil
 }
 
+// printPodErrors prints a summary of the pods that could not be collected.
+func printPodErrors(podErrors map[string]error) {
+	if len(podErrors) == 0 {
+		return
+	}
+	var names []string
+	for namespacePodName := range podErrors {
+		names = append(names, namespacePodName)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Error getting endpoints from %d pod(s):\n", len(podErrors))
+	for _, namespacePodName := range names {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", namespacePodName, podErrors[namespacePodName])
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+func getClustersFromPod(ctx context.Context, pod *corev1.Pod, client kube.CLIClient) (*envoy.Clusters, error) {
+	// kubectl exec to endpoint to get stats
+	stats, err := getEndpointsFromPod(ctx, pod, client)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatsIntoEndpointInfo(stats)
+}
+
 func parseStatsIntoEndpointInfo(stats string) (*envoy.Clusters, error) {
 	var clusters envoy.Clusters
 	err := json.Unmarshal([]byte(stats), &clusters)
@@ -212,14 +300,17 @@ func parseStatsIntoEndpointInfo(stats string) (*envoy.Clusters, error) {
 	return &clusters, nil
 }
 
-func getEndpointsFromPod(pod *corev1.Pod, client kube.CLIClient) (string, error) {
-	runner := kubectlcmd.NewRunner(-1)
-	runner.SetClient(client)
-	response, err := runner.EnvoyGet(pod.Namespace, pod.Name, "clusters?format=json", false)
+func getEndpointsFromPod(ctx context.Context, pod *corev1.Pod, client kube.CLIClient) (string, error) {
+	return envoyGet(ctx, pod, client, "clusters?format=json")
+}
+
+// envoyGet sends a GET request to the Envoy admin API of the pod's istio-proxy container.
+func envoyGet(ctx context.Context, pod *corev1.Pod, client kube.CLIClient, path string) (string, error) {
+	response, err := client.EnvoyDo(ctx, pod.Name, pod.Namespace, "GET", path)
 	if err != nil {
 		return "", err
 	}
-	return response, nil
+	return string(response), nil
 }
 
 func containsProxyContainer(pod *corev1.Pod) bool {
@@ -232,48 +323,6 @@ func containsProxyContainer(pod *corev1.Pod) bool {
 	return false
 }
 
-// Pod maps a pod name to its Pod info. The key is namespace/pod-name.
-func findPods(ctx context.Context, clientset *kubernetes.Clientset, args *EndpointsArgs) (map[string]*corev1.Pod, error) {
-	pods := map[string]*corev1.Pod{}
-	if args.PodName != "" {
-		pod, err := clientset.CoreV1().Pods(args.Namespace).Get(ctx, args.PodName, metav1.GetOptions{})
-		if err != nil {
-			return nil, err
-		}
-
-		pods[podNameNamespace(pod.Name, pod.Namespace)] = pod
-	} else if args.DeploymentName != "" {
-		// Get the deployment
-		deployment, err := clientset.AppsV1().Deployments(args.Namespace).Get(ctx, args.DeploymentName, metav1.GetOptions{})
-		if err != nil {
-			return nil, err
-		}
-
-		// List the pods with the same labels as the deployment
-		labelSelector := metav1.FormatLabelSelector(deployment.Spec.Selector)
-		deploymentPods, err := clientset.CoreV1().Pods(args.Namespace).List(ctx, metav1.ListOptions{
-			LabelSelector: labelSelector,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		for _, pod := range deploymentPods.Items {
-			pods[podNameNamespace(pod.Name, pod.Namespace)] = &pod
-		}
-	} else { // namespace only
-		podsList, err := clientset.CoreV1().Pods(args.Namespace).List(ctx, metav1.ListOptions{})
-		if err != nil {
-			return nil, err
-		}
-		for _, pod := range podsList.Items {
-			pods[podNameNamespace(pod.Name, pod.Namespace)] = &pod
-		}
-	}
-	fmt.Println("found", len(pods), "pod(s)")
-	return pods, nil
-}
-
 func podNameNamespace(name string, namespace string) string {
 	return fmt.Sprintf("%s/%s", namespace, name)
 }
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"github.com/nmnellis/mesh-helper/internal/domain/ztunnel"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ambientRedirectionAnnotation is set by the Istio CNI on pods whose traffic is redirected to ztunnel.
+const ambientRedirectionAnnotation = "ambient.istio.io/redirection"
+
+func isAmbientPod(pod *corev1.Pod) bool {
+	return pod.Annotations[ambientRedirectionAnnotation] == "enabled"
+}
+
+// getAmbientEndpointInformation reads the endpoints of ambient pods from the ztunnel running on their node, and
+// the Envoy clusters of the waypoints they use, with the same bounded worker pool as the sidecar pods. The config
+// dump of each ztunnel is read once. The services a pod is an endpoint of are shown as inbound clusters, ztunnel
+// does not record which services a pod connects to.
+func getAmbientEndpointInformation(ctx context.Context, pods []*corev1.Pod, client kube.CLIClient, concurrency int, podEndpoints map[string]*envoy.Clusters, podErrors map[string]error) {
+	type nodeDump struct {
+		once sync.Once
+		dump *ztunnel.ConfigDump
+		err  error
+	}
+	podsByName := map[string]*corev1.Pod{}
+	dumps := map[string]*nodeDump{}
+	var names []string
+	for _, pod := range pods {
+		namespacePodName := podNameNamespace(pod.Name, pod.Namespace)
+		podsByName[namespacePodName] = pod
+		names = append(names, namespacePodName)
+		if dumps[pod.Spec.NodeName] == nil {
+			dumps[pod.Spec.NodeName] = &nodeDump{}
+		}
+	}
+
+	var mu sync.Mutex
+	waypoints := map[string]bool{}
+	ambientErrors := forEachPod(names, concurrency, "collecting ambient endpoints", func(namespacePodName string) error {
+		pod := podsByName[namespacePodName]
+		node := dumps[pod.Spec.NodeName]
+		node.once.Do(func() {
+			node.dump, node.err = getZtunnelConfigDump(ctx, pod.Spec.NodeName, client)
+		})
+		if node.err != nil {
+			return node.err
+		}
+
+		workload := node.dump.PodWorkload(pod.Namespace, pod.Name)
+		if workload == nil {
+			return fmt.Errorf("ztunnel on node %s does not know the pod", pod.Spec.NodeName)
+		}
+		clusters := ztunnelClusters(node.dump, workload)
+		mu.Lock()
+		defer mu.Unlock()
+		podEndpoints[namespacePodName] = clusters
+		for _, waypoint := range podWaypoints(node.dump, workload) {
+			waypoints[waypoint] = true
+		}
+		return nil
+	})
+	for namespacePodName, err := range ambientErrors {
+		podErrors[namespacePodName] = err
+	}
+
+	var waypointNames []string
+	for namespacePodName := range waypoints {
+		if _, ok := podEndpoints[namespacePodName]; !ok {
+			waypointNames = append(waypointNames, namespacePodName)
+		}
+	}
+	sort.Strings(waypointNames)
+	waypointErrors := forEachPod(waypointNames, concurrency, "collecting waypoint endpoints", func(namespacePodName string) error {
+		namespace, name := splitNamespacePodName(namespacePodName)
+		pod, err := client.Kube().CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		clusters, err := getClustersFromPod(ctx, pod, client)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		podEndpoints[namespacePodName] = clusters
+		mu.Unlock()
+		return nil
+	})
+	for namespacePodName, err := range waypointErrors {
+		podErrors[namespacePodName] = err
+	}
+}
+
+func getZtunnelConfigDump(ctx context.Context, node string, client kube.CLIClient) (*ztunnel.ConfigDump, error) {
+	ztunnels, err := client.Kube().CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=ztunnel",
+		FieldSelector: "spec.nodeName=" + node,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ztunnels.Items) == 0 {
+		return nil, fmt.Errorf("no ztunnel found on node %s", node)
+	}
+	pod := &ztunnels.Items[0]
+	response, err := envoyGet(ctx, pod, client, "config_dump")
+	if err != nil {
+		return nil, err
+	}
+	var dump ztunnel.ConfigDump
+	if err := json.Unmarshal([]byte(response), &dump); err != nil {
+		return nil, fmt.Errorf("could not parse the config dump of ztunnel %s: %s", pod.Name, err)
+	}
+	return &dump, nil
+}
+
+// ztunnelClusters converts the services the workload is an endpoint of into inbound Envoy clusters, one per
+// service port, holding the workload itself like the inbound cluster of a sidecar. ztunnel does not keep per
+// endpoint stats, so the hosts have none.
+func ztunnelClusters(dump *ztunnel.ConfigDump, workload *ztunnel.Workload) *envoy.Clusters {
+	clusters := &envoy.Clusters{}
+	for _, service := range dump.ServicesOf(workload) {
+		var ports []string
+		for port := range service.Ports {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		for _, port := range ports {
+			cluster := envoy.ClusterStatus{Name: fmt.Sprintf("inbound|%s||%s", port, service.Hostname)}
+			for _, endpoint := range service.Endpoints {
+				if endpoint.WorkloadUID != workload.UID {
+					continue
+				}
+				cluster.HostStatuses = append(cluster.HostStatuses, ztunnelHost(endpoint, workload, port, service.Ports[port]))
+			}
+			clusters.ClusterStatuses = append(clusters.ClusterStatuses, cluster)
+		}
+	}
+	return clusters
+}
+
+func ztunnelHost(endpoint *ztunnel.Endpoint, workload *ztunnel.Workload, servicePort string, targetPort int) envoy.HostStatus {
+	address := ztunnel.StripNetwork(endpoint.Address)
+	if address == "" && len(workload.WorkloadIPs) > 0 {
+		address = ztunnel.StripNetwork(workload.WorkloadIPs[0])
+	}
+	if port, ok := endpoint.Port[servicePort]; ok {
+		targetPort = port
+	}
+	health := "HEALTHY"
+	if !workload.Healthy() {
+		health = "UNHEALTHY"
+	}
+	return envoy.HostStatus{
+		Address:      envoy.Address{SocketAddress: envoy.SocketAddress{Address: address, PortValue: targetPort}},
+		HealthStatus: envoy.HealthStatus{EdsHealthStatus: health},
+		Weight:       1,
+		Hostname:     workload.Name,
+		Locality: envoy.Locality{
+			Region:  workload.Locality.Region,
+			Zone:    workload.Locality.Zone,
+			SubZone: workload.Locality.Subzone,
+		},
+	}
+}
+
+// podWaypoints returns the namespace/pod names of the waypoints used by the workload or by its services.
+func podWaypoints(dump *ztunnel.ConfigDump, workload *ztunnel.Workload) []string {
+	addresses := []*ztunnel.GatewayAddress{workload.Waypoint}
+	for _, service := range dump.ServicesOf(workload) {
+		addresses = append(addresses, service.Waypoint)
+	}
+
+	var waypoints []string
+	for _, address := range addresses {
+		if address == nil {
+			continue
+		}
+		service := dump.Service(address)
+		if service == nil {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if namespace, name, ok := ztunnel.PodName(endpoint.WorkloadUID); ok {
+				waypoints = append(waypoints, podNameNamespace(name, namespace))
+			}
+		}
+	}
+	return waypoints
+}
+
+func splitNamespacePodName(namespacePodName string) (string, string) {
+	namespace, name, _ := strings.Cut(namespacePodName, "/")
+	return namespace, name
+}
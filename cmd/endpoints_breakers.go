@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"sigs.k8s.io/yaml"
+	"strconv"
+	"strings"
+)
+
+const (
+	// unlimitedThreshold is the value Istio uses for circuit breaker limits that are not configured.
+	unlimitedThreshold = 4294967295
+	// nearLimitRatio flags clusters using at least this share of a circuit breaker limit.
+	nearLimitRatio = 0.8
+)
+
+// breakerRecord summarises the circuit breakers and outlier detection of a cluster as seen by one pod's proxy.
+type breakerRecord struct {
+	Pod                  string   `json:"pod"`
+	Cluster              string   `json:"cluster"`
+	MaxConnections       int      `json:"max_connections"`
+	MaxPendingRequests   int      `json:"max_pending_requests"`
+	MaxRequests          int      `json:"max_requests"`
+	MaxRetries           int      `json:"max_retries"`
+	CxActive             int64    `json:"cx_active"`
+	RqActive             int64    `json:"rq_active"`
+	Hosts                int      `json:"hosts"`
+	EjectedHosts         int      `json:"ejected_hosts"`
+	SuccessRateThreshold *float64 `json:"success_rate_ejection_threshold,omitempty"`
+	LowestSuccessRate    *float64 `json:"lowest_success_rate,omitempty"`
+	Warnings             []string `json:"warnings,omitempty"`
+	displayName          string
+}
+
+func buildBreakerRecords(clusters map[string]*envoy.Clusters, args *EndpointsArgs) []*breakerRecord {
+	records := []*breakerRecord{}
+	for _, namespacePodName := range sortedPodNames(clusters) {
+		for _, s := range clusters[namespacePodName].ClusterStatuses {
+			name := envoy.ParseClusterName(s.Name)
+			if !clusterMatches(s.Name, name, args) {
+				continue
+			}
+			record := newBreakerRecord(namespacePodName, s)
+			record.displayName = clusterDisplayName(s.Name, name)
+			if record.CxActive == 0 && record.RqActive == 0 && len(record.Warnings) == 0 && !args.IncludeIdle {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func newBreakerRecord(namespacePodName string, s envoy.ClusterStatus) *breakerRecord {
+	record := &breakerRecord{
+		Pod:          namespacePodName,
+		Cluster:      s.Name,
+		Hosts:        len(s.HostStatuses),
+		EjectedHosts: s.EjectedHosts(),
+	}
+	threshold, ok := s.CircuitBreakers.DefaultThreshold()
+	if ok {
+		record.MaxConnections = threshold.MaxConnections
+		record.MaxPendingRequests = threshold.MaxPendingRequests
+		record.MaxRequests = threshold.MaxRequests
+		record.MaxRetries = threshold.MaxRetries
+	}
+	for _, hs := range s.HostStatuses {
+		record.CxActive += hs.StatValue("cx_active")
+		record.RqActive += hs.StatValue("rq_active")
+		if hs.SuccessRate != nil && (record.LowestSuccessRate == nil || hs.SuccessRate.Value < *record.LowestSuccessRate) {
+			rate := hs.SuccessRate.Value
+			record.LowestSuccessRate = &rate
+		}
+	}
+	if s.SuccessRateEjectionThreshold != nil {
+		value := s.SuccessRateEjectionThreshold.Value
+		record.SuccessRateThreshold = &value
+	}
+
+	if nearLimit(record.CxActive, record.MaxConnections) {
+		record.Warnings = append(record.Warnings, "NEAR_MAX_CONNECTIONS")
+	}
+	if nearLimit(record.RqActive, record.MaxRequests) {
+		record.Warnings = append(record.Warnings, "NEAR_MAX_REQUESTS")
+	}
+	if record.EjectedHosts > 0 {
+		record.Warnings = append(record.Warnings, "EJECTED_HOSTS")
+	}
+	return record
+}
+
+func nearLimit(active int64, limit int) bool {
+	if limit <= 0 || limit >= unlimitedThreshold {
+		return false
+	}
+	return float64(active) >= float64(limit)*nearLimitRatio
+}
+
+func printBreakerInfo(clusters map[string]*envoy.Clusters, args *EndpointsArgs) error {
+	records := buildBreakerRecords(clusters, args)
+
+	switch args.Output {
+	case "table", "wide":
+		printBreakerTables(sortedPodNames(clusters), records)
+	case "json":
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	default:
+		return fmt.Errorf("unsupported output format %q for --breakers", args.Output)
+	}
+	return nil
+}
+
+func printBreakerTables(pods []string, records []*breakerRecord) {
+	recordsByPod := map[string][]*breakerRecord{}
+	for _, record := range records {
+		recordsByPod[record.Pod] = append(recordsByPod[record.Pod], record)
+	}
+
+	for _, namespacePodName := range pods {
+		podRecords := recordsByPod[namespacePodName]
+		if len(podRecords) == 0 {
+			continue
+		}
+		tbl := newTable("Cluster", "Cx Active", "Max Connections", "Rq Active", "Max Requests", "Max Pending", "Max Retries",
+			"Hosts", "Ejected", "SR Threshold", "Lowest SR", "Status")
+		for _, r := range podRecords {
+			status := "OK"
+			if len(r.Warnings) > 0 {
+				status = color.RedString(strings.Join(r.Warnings, ","))
+			}
+			tbl.AddRow(r.displayName, r.CxActive, limitCell(r.MaxConnections), r.RqActive, limitCell(r.MaxRequests),
+				limitCell(r.MaxPendingRequests), limitCell(r.MaxRetries), r.Hosts, r.EjectedHosts,
+				percentCell(r.SuccessRateThreshold), percentCell(r.LowestSuccessRate), status)
+		}
+		fmt.Printf("%s\n", namespacePodName)
+		tbl.Print()
+		fmt.Print("\n\n")
+	}
+}
+
+func limitCell(limit int) string {
+	if limit >= unlimitedThreshold {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
+}
+
+func percentCell(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.1f%%", *value)
+}
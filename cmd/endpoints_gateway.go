@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	networkingv1 "istio.io/api/networking/v1"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"strings"
+)
+
+// gatewayPodSelector finds the label selector of the gateway pods. Istio gateways are selected by their istio label,
+// e.g. istio=ingressgateway for istio-ingressgateway, and Gateway API gateways by their gateway name label.
+func gatewayPodSelector(ctx context.Context, client kube.CLIClient, namespace string, name string) (string, error) {
+	candidates := []string{
+		"istio=" + name,
+		"istio=" + strings.TrimPrefix(name, "istio-"),
+		"gateway.networking.k8s.io/gateway-name=" + name,
+	}
+	for _, selector := range candidates {
+		pods, err := client.Kube().CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector, Limit: 1})
+		if err != nil {
+			return "", err
+		}
+		if len(pods.Items) > 0 {
+			return selector, nil
+		}
+	}
+	return "", fmt.Errorf("no gateway pods found for %s/%s", namespace, name)
+}
+
+// parseGatewayFlag splits --gateway into its namespace and name, the namespace defaults to --namespace.
+func parseGatewayFlag(args *PodSelectorArgs) (string, string) {
+	if namespace, name, ok := strings.Cut(args.Gateway, "/"); ok {
+		return namespace, name
+	}
+	return args.Namespace, args.Gateway
+}
+
+// routeTarget is the destination of a route. A zero port matches every port of the service.
+type routeTarget struct {
+	fqdn   string
+	port   int
+	subset string
+}
+
+// routeHost is a host of a VirtualService or HTTPRoute bound to the gateway.
+type routeHost struct {
+	Host  string
+	Route string
+}
+
+func (h routeHost) String() string {
+	return fmt.Sprintf("%s (%s)", h.Host, h.Route)
+}
+
+// gatewayRouteHosts finds the VirtualServices bound to the Istio Gateways that select the gateway pods, and the
+// HTTPRoutes attached to the Gateway API gateway, and maps their destinations to the hosts they serve.
+func gatewayRouteHosts(ctx context.Context, client kube.CLIClient, pods map[string]*corev1.Pod, namespace string, name string) (map[routeTarget][]routeHost, error) {
+	routes := map[routeTarget][]routeHost{}
+
+	gateways, err := istioGatewaysForPods(ctx, client, pods)
+	if err != nil {
+		return nil, err
+	}
+	virtualServices, err := client.Istio().NetworkingV1().VirtualServices(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, vs := range virtualServices.Items {
+		if !boundToGateway(vs.Spec.Gateways, vs.Namespace, gateways) {
+			continue
+		}
+		var destinations []*networkingv1.RouteDestination
+		for _, route := range vs.Spec.Http {
+			for _, destination := range route.Route {
+				destinations = append(destinations, &networkingv1.RouteDestination{Destination: destination.Destination})
+			}
+		}
+		for _, route := range vs.Spec.Tls {
+			destinations = append(destinations, route.Route...)
+		}
+		for _, route := range vs.Spec.Tcp {
+			destinations = append(destinations, route.Route...)
+		}
+		for _, destination := range destinations {
+			target := routeTarget{
+				fqdn:   serviceFQDN(destination.GetDestination().GetHost(), vs.Namespace),
+				port:   int(destination.GetDestination().GetPort().GetNumber()),
+				subset: destination.GetDestination().GetSubset(),
+			}
+			for _, host := range vs.Spec.Hosts {
+				routes[target] = append(routes[target], routeHost{Host: host, Route: "VirtualService " + vs.Namespace + "/" + vs.Name})
+			}
+		}
+	}
+
+	httpRoutes, err := client.GatewayAPI().GatewayV1().HTTPRoutes(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+		// the Gateway API CRDs are not installed, only VirtualServices route to the gateway
+		return routes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, route := range httpRoutes.Items {
+		attached := false
+		for _, parent := range route.Spec.ParentRefs {
+			parentNamespace := route.Namespace
+			if parent.Namespace != nil {
+				parentNamespace = string(*parent.Namespace)
+			}
+			if (parent.Kind == nil || *parent.Kind == "Gateway") && string(parent.Name) == name && parentNamespace == namespace {
+				attached = true
+			}
+		}
+		if !attached {
+			continue
+		}
+		hosts := []string{"*"}
+		if len(route.Spec.Hostnames) > 0 {
+			hosts = nil
+			for _, hostname := range route.Spec.Hostnames {
+				hosts = append(hosts, string(hostname))
+			}
+		}
+		for _, rule := range route.Spec.Rules {
+			for _, backend := range rule.BackendRefs {
+				if backend.Kind != nil && *backend.Kind != "Service" {
+					continue
+				}
+				backendNamespace := route.Namespace
+				if backend.Namespace != nil {
+					backendNamespace = string(*backend.Namespace)
+				}
+				target := routeTarget{fqdn: serviceFQDN(string(backend.Name), backendNamespace)}
+				if backend.Port != nil {
+					target.port = int(*backend.Port)
+				}
+				for _, host := range hosts {
+					routes[target] = append(routes[target], routeHost{Host: host, Route: "HTTPRoute " + route.Namespace + "/" + route.Name})
+				}
+			}
+		}
+	}
+	return routes, nil
+}
+
+// istioGatewaysForPods returns the namespace/name of the Istio Gateways whose selector matches the gateway pods.
+func istioGatewaysForPods(ctx context.Context, client kube.CLIClient, pods map[string]*corev1.Pod) (map[string]bool, error) {
+	gatewayList, err := client.Istio().NetworkingV1().Gateways(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	gateways := map[string]bool{}
+	for _, gateway := range gatewayList.Items {
+		if len(gateway.Spec.Selector) == 0 {
+			continue
+		}
+		selector := labels.SelectorFromSet(gateway.Spec.Selector)
+		for _, pod := range pods {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				gateways[gateway.Namespace+"/"+gateway.Name] = true
+				break
+			}
+		}
+	}
+	return gateways, nil
+}
+
+// boundToGateway reports whether one of the VirtualService gateways is in the set. Gateways without a namespace
+// are in the namespace of the VirtualService.
+func boundToGateway(vsGateways []string, vsNamespace string, gateways map[string]bool) bool {
+	for _, gateway := range vsGateways {
+		if !strings.Contains(gateway, "/") {
+			gateway = vsNamespace + "/" + gateway
+		}
+		if gateways[gateway] {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceFQDN expands a short service name the way Istio does, using the namespace of the route.
+func serviceFQDN(host string, namespace string) string {
+	if strings.Contains(host, ".") {
+		return host
+	}
+	return host + "." + namespace + ".svc.cluster.local"
+}
+
+// routeHostsFor returns the hosts routing to the cluster, sorted and without duplicates.
+func routeHostsFor(name envoy.ClusterName, routes map[routeTarget][]routeHost) []routeHost {
+	seen := map[routeHost]bool{}
+	var hosts []routeHost
+	for _, port := range []int{name.Port, 0} {
+		for _, host := range routes[routeTarget{fqdn: name.FQDN, port: port, subset: name.Subset}] {
+			if !seen[host] {
+				seen[host] = true
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].String() < hosts[j].String()
+	})
+	return hosts
+}
+
+// printGatewayEndpointInfo prints the endpoints of the gateway pods grouped by the route hosts that send traffic to
+// each cluster. Clusters no route points to are printed last.
+func printGatewayEndpointInfo(clusters map[string]*envoy.Clusters, routes map[routeTarget][]routeHost, args *EndpointsArgs) error {
+	records := buildEndpointRecords(clusters, args)
+	hostsByRecord := map[*endpointRecord][]routeHost{}
+	for _, r := range records {
+		hostsByRecord[r] = routeHostsFor(envoy.ParseClusterName(r.Cluster), routes)
+		for _, host := range hostsByRecord[r] {
+			r.RouteHosts = append(r.RouteHosts, host.Host)
+		}
+	}
+	if args.Output != "table" && args.Output != "wide" {
+		return printEndpointRecords(records, args)
+	}
+
+	recordsByPod := map[string][]*endpointRecord{}
+	for _, record := range records {
+		recordsByPod[record.Pod] = append(recordsByPod[record.Pod], record)
+	}
+	var noEndpointsPods []string
+	for _, namespacePodName := range sortedPodNames(clusters) {
+		podRecords := recordsByPod[namespacePodName]
+		if len(podRecords) == 0 {
+			noEndpointsPods = append(noEndpointsPods, namespacePodName)
+			continue
+		}
+
+		byHost := map[string][]*endpointRecord{}
+		var unrouted []*endpointRecord
+		for _, r := range podRecords {
+			if len(hostsByRecord[r]) == 0 {
+				unrouted = append(unrouted, r)
+			}
+			for _, host := range hostsByRecord[r] {
+				byHost[host.String()] = append(byHost[host.String()], r)
+			}
+		}
+		var hosts []string
+		for host := range byHost {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		fmt.Printf("%s\n", namespacePodName)
+		for _, host := range hosts {
+			fmt.Printf("\nHost %s\n", host)
+			printEndpointTable(byHost[host], args.Output == "wide")
+		}
+		if len(unrouted) > 0 {
+			fmt.Printf("\nNot routed by a VirtualService or HTTPRoute\n")
+			printEndpointTable(unrouted, args.Output == "wide")
+		}
+		fmt.Print("\n\n")
+	}
+	printNoEndpointsPods(noEndpointsPods, args)
+	return nil
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sort"
+)
+
+const unknownZone = "unknown"
+
+// localityReport is the outbound traffic of one pod grouped by the zone of the destination hosts.
+type localityReport struct {
+	Pod          string         `json:"pod"`
+	Region       string         `json:"region,omitempty"`
+	Zone         string         `json:"zone"`
+	Requests     int64          `json:"requests"`
+	SameZone     int64          `json:"same_zone"`
+	CrossZone    int64          `json:"cross_zone"`
+	UnknownZone  int64          `json:"unknown_zone"`
+	Destinations []*zoneTraffic `json:"destinations"`
+}
+
+// zoneTraffic is the number of requests a pod sent to the hosts of one zone, per cluster with -o wide.
+type zoneTraffic struct {
+	Cluster  string `json:"cluster,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Zone     string `json:"zone"`
+	Requests int64  `json:"requests"`
+	// CrossZone is true when the zone or region differs from the source pod's, unset when either is unknown.
+	CrossZone *bool `json:"cross_zone,omitempty"`
+}
+
+// printLocalityReport groups the rq_total of every outbound host by the zone of its EDS locality and compares it with
+// the zone of the node each source pod runs on.
+func printLocalityReport(ctx context.Context, client kube.CLIClient, pods map[string]*corev1.Pod, endpointInfo map[string]*envoy.Clusters, args *EndpointsArgs) error {
+	nodes := map[string]*corev1.Node{}
+	var reports []*localityReport
+	for _, namespacePodName := range sortedPodNames(endpointInfo) {
+		source := envoy.Locality{}
+		if pod, ok := pods[namespacePodName]; ok && pod.Spec.NodeName != "" {
+			node, ok := nodes[pod.Spec.NodeName]
+			if !ok {
+				var err error
+				node, err = client.Kube().CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				nodes[pod.Spec.NodeName] = node
+			}
+			source = nodeLocality(node)
+		}
+		reports = append(reports, buildLocalityReport(namespacePodName, source, endpointInfo[namespacePodName], args))
+	}
+
+	switch args.Output {
+	case "table", "wide":
+		printLocalityTables(reports, args.Output == "wide")
+	case "json":
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unsupported output format %q for --locality-report", args.Output)
+	}
+	return nil
+}
+
+// nodeLocality reads the well known topology labels, falling back to the deprecated failure-domain ones.
+func nodeLocality(node *corev1.Node) envoy.Locality {
+	locality := envoy.Locality{
+		Region: node.Labels[corev1.LabelTopologyRegion],
+		Zone:   node.Labels[corev1.LabelTopologyZone],
+	}
+	if locality.Region == "" {
+		locality.Region = node.Labels[corev1.LabelFailureDomainBetaRegion]
+	}
+	if locality.Zone == "" {
+		locality.Zone = node.Labels[corev1.LabelFailureDomainBetaZone]
+	}
+	return locality
+}
+
+func buildLocalityReport(namespacePodName string, source envoy.Locality, clusters *envoy.Clusters, args *EndpointsArgs) *localityReport {
+	report := &localityReport{Pod: namespacePodName, Region: source.Region, Zone: source.Zone}
+	if report.Zone == "" {
+		report.Zone = unknownZone
+	}
+
+	traffic := map[string]*zoneTraffic{}
+	for _, s := range clusters.ClusterStatuses {
+		name := envoy.ParseClusterName(s.Name)
+		if name.Direction != "outbound" || !clusterMatches(s.Name, name, args) {
+			continue
+		}
+		for _, hs := range s.HostStatuses {
+			requests := hs.StatValue("rq_total")
+			if requests == 0 {
+				continue
+			}
+			destination := &zoneTraffic{Region: hs.Locality.Region, Zone: hs.Locality.Zone}
+			if args.Output == "wide" {
+				destination.Cluster = clusterDisplayName(s.Name, name)
+			}
+			if destination.Zone == "" {
+				destination.Zone = unknownZone
+			}
+			key := destination.Cluster + "|" + destination.Region + "|" + destination.Zone
+			if existing, ok := traffic[key]; ok {
+				destination = existing
+			} else {
+				traffic[key] = destination
+				report.Destinations = append(report.Destinations, destination)
+			}
+			destination.Requests += requests
+			report.Requests += requests
+
+			switch {
+			case source.Zone == "" || hs.Locality.Zone == "":
+				report.UnknownZone += requests
+			case source.Zone == hs.Locality.Zone && (source.Region == "" || hs.Locality.Region == "" || source.Region == hs.Locality.Region):
+				crossZone := false
+				destination.CrossZone = &crossZone
+				report.SameZone += requests
+			default:
+				crossZone := true
+				destination.CrossZone = &crossZone
+				report.CrossZone += requests
+			}
+		}
+	}
+	sort.Slice(report.Destinations, func(i, j int) bool {
+		a, b := report.Destinations[i], report.Destinations[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return a.Requests > b.Requests
+	})
+	return report
+}
+
+func printLocalityTables(reports []*localityReport, wide bool) {
+	for _, report := range reports {
+		if report.Requests == 0 {
+			continue
+		}
+		fmt.Printf("%s (zone %s)\n", report.Pod, report.Zone)
+		headers := []interface{}{"Destination Zone", "Requests", "Percent"}
+		if wide {
+			headers = append([]interface{}{"Cluster"}, headers...)
+		}
+		tbl := newTable(headers...)
+		for _, d := range report.Destinations {
+			zone := d.Zone
+			if d.Region != "" {
+				zone = d.Region + "/" + d.Zone
+			}
+			row := []interface{}{zone, d.Requests, shareCell(d.Requests, report.Requests)}
+			if wide {
+				row = append([]interface{}{d.Cluster}, row...)
+			}
+			if d.CrossZone != nil && *d.CrossZone {
+				row = colorRow(row, color.FgYellow)
+			}
+			tbl.AddRow(row...)
+		}
+		tbl.Print()
+		fmt.Print("\n\n")
+	}
+
+	tbl := newTable("Pod", "Zone", "Requests", "Same Zone", "Cross Zone", "Unknown Zone")
+	var requests, crossZone int64
+	for _, report := range reports {
+		requests += report.Requests
+		crossZone += report.CrossZone
+		tbl.AddRow(report.Pod, report.Zone, report.Requests, shareCell(report.SameZone, report.Requests),
+			shareCell(report.CrossZone, report.Requests), shareCell(report.UnknownZone, report.Requests))
+	}
+	tbl.Print()
+	fmt.Printf("\n%s of %d outbound request(s) crossed zones\n", shareCell(crossZone, requests), requests)
+}
+
+func shareCell(part int64, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
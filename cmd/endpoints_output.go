@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"os"
+	"path"
+	"sigs.k8s.io/yaml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// endpointRecord is a single host of a cluster as seen by one pod's proxy.
+type endpointRecord struct {
+	Pod       string `json:"pod"`
+	Cluster   string `json:"cluster"`
+	Direction string `json:"direction,omitempty"`
+	Service   string `json:"service"`
+	Subset    string `json:"subset,omitempty"`
+	Address   string `json:"address"`
+	Port      int    `json:"port"`
+	Hostname  string `json:"hostname,omitempty"`
+	Health    string `json:"health"`
+	Healthy   bool   `json:"healthy"`
+	// FailedChecks holds the failed active or outlier health check flags.
+	FailedChecks []string         `json:"failed_checks,omitempty"`
+	Weight       int              `json:"weight"`
+	Priority     int              `json:"priority"`
+	Locality     string           `json:"locality,omitempty"`
+	Stats        map[string]int64 `json:"stats"`
+	// RouteHosts are the VirtualService or HTTPRoute hosts routing to the cluster, only set with --gateway.
+	RouteHosts []string `json:"route_hosts,omitempty"`
+}
+
+func (r *endpointRecord) displayName() string {
+	return clusterDisplayName(r.Cluster, envoy.ParseClusterName(r.Cluster))
+}
+
+// clusterDisplayName is the service of an outbound cluster, or the whole name for inbound and non-service clusters.
+func clusterDisplayName(clusterName string, name envoy.ClusterName) string {
+	if name.Direction == "outbound" && name.FQDN != "" {
+		return name.FQDN
+	}
+	return clusterName
+}
+
+// statColumns are the host stats Envoy reports, in the order they are printed.
+var statColumns = []string{"rq_success", "rq_error", "rq_timeout", "rq_total", "rq_active", "cx_active", "cx_connect_fail", "cx_total"}
+
+// buildEndpointRecords flattens the clusters of every pod into records, sorted by pod. Only the hosts of clusters
+// matching the --direction and --cluster filters are kept, and idle hosts only with --include-idle.
+func buildEndpointRecords(clusters map[string]*envoy.Clusters, args *EndpointsArgs) []*endpointRecord {
+	records := []*endpointRecord{}
+	for _, namespacePodName := range sortedPodNames(clusters) {
+		for _, s := range clusters[namespacePodName].ClusterStatuses {
+			name := envoy.ParseClusterName(s.Name)
+			if !clusterMatches(s.Name, name, args) {
+				continue
+			}
+			for _, hs := range s.HostStatuses {
+				if hs.IsIdle() && !args.IncludeIdle {
+					continue
+				}
+				records = append(records, newEndpointRecord(namespacePodName, s, name, hs))
+			}
+		}
+	}
+	return records
+}
+
+func validateEndpointFilters(args *EndpointsArgs) error {
+	switch args.Direction {
+	case "inbound", "outbound", "all":
+	default:
+		return fmt.Errorf("unsupported direction %q, use inbound, outbound or all", args.Direction)
+	}
+	if _, err := path.Match(args.Cluster, ""); err != nil {
+		return fmt.Errorf("invalid --cluster glob %q: %s", args.Cluster, err)
+	}
+	return nil
+}
+
+// clusterMatches applies the --direction filter and matches the --cluster glob against the FQDN, subset and port
+// parts of the cluster name, or the whole name.
+func clusterMatches(clusterName string, name envoy.ClusterName, args *EndpointsArgs) bool {
+	if args.Direction != "all" && name.Direction != args.Direction {
+		return false
+	}
+	if args.Cluster == "" {
+		return true
+	}
+	for _, part := range []string{clusterName, name.FQDN, name.Subset, strconv.Itoa(name.Port)} {
+		if matched, _ := path.Match(args.Cluster, part); matched && part != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func newEndpointRecord(namespacePodName string, s envoy.ClusterStatus, name envoy.ClusterName, hs envoy.HostStatus) *endpointRecord {
+	stats := map[string]int64{}
+	for _, stat := range hs.Stats {
+		stats[stat.Name] = hs.StatValue(stat.Name)
+	}
+	return &endpointRecord{
+		Pod:          namespacePodName,
+		Cluster:      s.Name,
+		Direction:    name.Direction,
+		Service:      name.FQDN,
+		Subset:       name.Subset,
+		Address:      hs.Address.SocketAddress.Address,
+		Port:         hs.Address.SocketAddress.PortValue,
+		Hostname:     hs.Hostname,
+		Health:       hs.HealthStatus.EdsHealthStatus,
+		Healthy:      hs.HealthStatus.Healthy(),
+		FailedChecks: hs.HealthStatus.FailedChecks(),
+		Weight:       hs.Weight,
+		Priority:     hs.Priority,
+		Locality:     hs.Locality.String(),
+		Stats:        stats,
+	}
+}
+
+func sortedPodNames(clusters map[string]*envoy.Clusters) []string {
+	var names []string
+	for namespacePodName := range clusters {
+		names = append(names, namespacePodName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printEndpointInfo(clusters map[string]*envoy.Clusters, args *EndpointsArgs) error {
+	records := buildEndpointRecords(clusters, args)
+	if args.Output == "table" || args.Output == "wide" {
+		printEndpointTables(sortedPodNames(clusters), records, args)
+		return nil
+	}
+	return printEndpointRecords(records, args)
+}
+
+// printEndpointRecords prints the records in the machine readable output formats.
+func printEndpointRecords(records []*endpointRecord, args *EndpointsArgs) error {
+	switch args.Output {
+	case "json":
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	case "csv":
+		return writeEndpointCSV(records, args.Gateway != "")
+	default:
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+	return nil
+}
+
+func printEndpointTables(pods []string, records []*endpointRecord, args *EndpointsArgs) {
+	wide := args.Output == "wide"
+	recordsByPod := map[string][]*endpointRecord{}
+	for _, record := range records {
+		recordsByPod[record.Pod] = append(recordsByPod[record.Pod], record)
+	}
+
+	var noEndpointsPods []string
+	for _, namespacePodName := range pods {
+		podRecords := recordsByPod[namespacePodName]
+		if len(podRecords) == 0 {
+			noEndpointsPods = append(noEndpointsPods, namespacePodName)
+			continue
+		}
+
+		fmt.Printf("%s\n", namespacePodName)
+		printEndpointTable(podRecords, wide)
+		fmt.Print("\n\n")
+	}
+	printNoEndpointsPods(noEndpointsPods, args)
+}
+
+func printEndpointTable(records []*endpointRecord, wide bool) {
+	headers := []interface{}{"Cluster", "Subset", "Endpoint", "Port", "Hostname", "Health", "Weight", "Rq Success", "Rq Error", "Cx Active", "Cx Connect Fail", "Priority", "Locality"}
+	if wide {
+		headers = append(headers, "Rq Timeout", "Rq Total", "Rq Active", "Cx Total")
+	}
+	tbl := newTable(headers...)
+	for _, r := range records {
+		row := []interface{}{r.displayName(), r.Subset, r.Address, r.Port, r.Hostname, r.healthCell(), r.Weight,
+			statCell(r, "rq_success"), statCell(r, "rq_error"), statCell(r, "cx_active"), statCell(r, "cx_connect_fail"),
+			priorityCell(r.Priority), r.Locality}
+		if wide {
+			row = append(row, statCell(r, "rq_timeout"), statCell(r, "rq_total"), statCell(r, "rq_active"),
+				statCell(r, "cx_total"))
+		}
+		if !r.Healthy {
+			row = colorRow(row, color.FgRed)
+		}
+		tbl.AddRow(row...)
+	}
+	tbl.Print()
+}
+
+func printNoEndpointsPods(noEndpointsPods []string, args *EndpointsArgs) {
+	description := args.Direction
+	if args.Direction == "all" {
+		description = "cluster"
+	}
+	if !args.IncludeIdle {
+		description += " active"
+	}
+	for _, pod := range noEndpointsPods {
+		fmt.Printf("\nNo %s endpoints found for %s", description, pod)
+	}
+	if len(noEndpointsPods) > 0 {
+		fmt.Println()
+	}
+}
+
+// healthCell combines the EDS health status with the failed health check flags.
+func (r *endpointRecord) healthCell() string {
+	health := r.Health
+	if health == "" {
+		health = "UNKNOWN"
+	}
+	for _, check := range r.FailedChecks {
+		health += "," + check
+	}
+	return health
+}
+
+// colorRow colors every cell of a table row.
+func colorRow(row []interface{}, attribute color.Attribute) []interface{} {
+	c := color.New(attribute)
+	colored := make([]interface{}, len(row))
+	for i, cell := range row {
+		colored[i] = c.Sprint(cell)
+	}
+	return colored
+}
+
+// statCell leaves zero stats blank to keep the tables readable.
+func statCell(r *endpointRecord, name string) string {
+	if r.Stats[name] == 0 {
+		return ""
+	}
+	return strconv.FormatInt(r.Stats[name], 10)
+}
+
+func priorityCell(priority int) string {
+	if priority == 0 {
+		return ""
+	}
+	return strconv.Itoa(priority)
+}
+
+func writeEndpointCSV(records []*endpointRecord, withRouteHosts bool) error {
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"pod", "cluster", "direction", "service", "subset", "address", "port", "hostname", "health", "healthy", "failed_checks", "weight", "priority", "locality"}
+	header = append(header, statColumns...)
+	if withRouteHosts {
+		header = append(header, "route_hosts")
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{r.Pod, r.Cluster, r.Direction, r.Service, r.Subset, r.Address, strconv.Itoa(r.Port), r.Hostname,
+			r.Health, strconv.FormatBool(r.Healthy), strings.Join(r.FailedChecks, ";"), strconv.Itoa(r.Weight),
+			strconv.Itoa(r.Priority), r.Locality}
+		for _, stat := range statColumns {
+			row = append(row, strconv.FormatInt(r.Stats[stat], 10))
+		}
+		if withRouteHosts {
+			row = append(row, strings.Join(r.RouteHosts, ";"))
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
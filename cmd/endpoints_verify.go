@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	endpointStale   = "STALE"
+	endpointMissing = "MISSING"
+)
+
+// verifyRecord is an address on which a proxy and the Service's EndpointSlices disagree.
+type verifyRecord struct {
+	Pod     string `json:"pod"`
+	Cluster string `json:"cluster"`
+	Address string `json:"address"`
+	// Problem is STALE when only the proxy has the address and MISSING when only Kubernetes has it.
+	Problem string `json:"problem"`
+}
+
+// verifyEndpoints compares the hosts of every outbound Kubernetes Service cluster with the ready addresses of the
+// Service's EndpointSlices. Subset clusters only hold some of the endpoints and are skipped. Clusters that are not
+// EDS, such as the ORIGINAL_DST clusters of headless Services, only hold the hosts already connected to and are
+// skipped as well.
+func verifyEndpoints(ctx context.Context, client kube.CLIClient, endpointInfo map[string]*envoy.Clusters, args *EndpointsArgs) error {
+	slicesByService := map[string][]discoveryv1.EndpointSlice{}
+	headlessByService := map[string]bool{}
+	var records []*verifyRecord
+	var verified, skipped int
+	for _, namespacePodName := range sortedPodNames(endpointInfo) {
+		for _, s := range endpointInfo[namespacePodName].ClusterStatuses {
+			name := envoy.ParseClusterName(s.Name)
+			service, namespace, ok := kubernetesService(name)
+			if !ok || name.Direction != "outbound" || name.Subset != "" || !clusterMatches(s.Name, name, args) {
+				continue
+			}
+			key := namespace + "/" + service
+			headless, ok := headlessByService[key]
+			if !ok {
+				svc, err := client.Kube().CoreV1().Services(namespace).Get(ctx, service, metav1.GetOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					return err
+				}
+				headless = err == nil && svc.Spec.ClusterIP == corev1.ClusterIPNone
+				headlessByService[key] = headless
+			}
+			// Envoy only reports an eds_service_name for EDS clusters
+			if headless || s.EdsServiceName == "" {
+				skipped++
+				continue
+			}
+			slices, ok := slicesByService[key]
+			if !ok {
+				list, err := client.Kube().DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+					LabelSelector: discoveryv1.LabelServiceName + "=" + service,
+				})
+				if err != nil {
+					return err
+				}
+				slices = list.Items
+				slicesByService[key] = slices
+			}
+			if len(s.HostStatuses) == 0 && len(slices) == 0 {
+				continue
+			}
+			verified++
+			records = append(records, compareClusterEndpoints(namespacePodName, s, slices)...)
+		}
+	}
+
+	switch args.Output {
+	case "table", "wide":
+		printVerifyTable(records, verified, skipped)
+	case "json":
+		if records == nil {
+			records = []*verifyRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unsupported output format %q for --verify", args.Output)
+	}
+	return nil
+}
+
+// kubernetesService returns the Service name and namespace of a <name>.<namespace>.svc.cluster.local cluster.
+func kubernetesService(name envoy.ClusterName) (string, string, bool) {
+	parts := strings.Split(name.FQDN, ".")
+	if len(parts) != 5 || !strings.HasSuffix(name.FQDN, ".svc.cluster.local") {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// compareClusterEndpoints reports the hosts of the cluster no EndpointSlice has on that port, and the ready
+// EndpointSlice addresses the cluster does not have at all.
+func compareClusterEndpoints(namespacePodName string, s envoy.ClusterStatus, slices []discoveryv1.EndpointSlice) []*verifyRecord {
+	known := map[string]bool{}
+	ready := map[string]bool{}
+	for _, slice := range slices {
+		for _, endpoint := range slice.Endpoints {
+			for _, address := range endpoint.Addresses {
+				for _, port := range slice.Ports {
+					if port.Port != nil {
+						known[net.JoinHostPort(address, strconv.Itoa(int(*port.Port)))] = true
+					}
+				}
+				if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+					ready[address] = true
+				}
+			}
+		}
+	}
+
+	var records []*verifyRecord
+	inEnvoy := map[string]bool{}
+	for _, hs := range s.HostStatuses {
+		address := hs.Address.SocketAddress.Address
+		inEnvoy[address] = true
+		hostPort := net.JoinHostPort(address, strconv.Itoa(hs.Address.SocketAddress.PortValue))
+		if !known[hostPort] {
+			records = append(records, &verifyRecord{Pod: namespacePodName, Cluster: s.Name, Address: hostPort, Problem: endpointStale})
+		}
+	}
+	var missing []string
+	for address := range ready {
+		if !inEnvoy[address] {
+			missing = append(missing, address)
+		}
+	}
+	sort.Strings(missing)
+	for _, address := range missing {
+		records = append(records, &verifyRecord{Pod: namespacePodName, Cluster: s.Name, Address: address, Problem: endpointMissing})
+	}
+	return records
+}
+
+func printVerifyTable(records []*verifyRecord, verified int, skipped int) {
+	if len(records) == 0 {
+		fmt.Printf("All %d cluster(s) match their EndpointSlices\n", verified)
+		printVerifySkipped(skipped)
+		return
+	}
+	tbl := newTable("Pod", "Cluster", "Address", "Problem")
+	clusters := map[string]bool{}
+	for _, r := range records {
+		clusters[r.Pod+"|"+r.Cluster] = true
+		tbl.AddRow(r.Pod, clusterDisplayName(r.Cluster, envoy.ParseClusterName(r.Cluster)), r.Address, color.RedString(r.Problem))
+	}
+	tbl.Print()
+	fmt.Printf("\n%d of %d cluster(s) differ from their EndpointSlices\n", len(clusters), verified)
+	printVerifySkipped(skipped)
+}
+
+func printVerifySkipped(skipped int) {
+	if skipped > 0 {
+		fmt.Printf("%d cluster(s) of headless Services or not using EDS were skipped, they only hold connected hosts\n", skipped)
+	}
+}
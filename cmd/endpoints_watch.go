@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"istio.io/istio/pkg/kube"
+	"strconv"
+	"time"
+)
+
+// clearScreen moves the cursor home and clears the terminal so every poll redraws in place.
+const clearScreen = "\033[H\033[2J"
+
+// watchEndpoints polls the selected pods every --interval and prints how much the host counters changed since the
+// previous poll. Pods are looked up again on every poll so restarted pods are picked up during an incident.
+func watchEndpoints(ctx context.Context, client kube.CLIClient, args *EndpointsArgs) error {
+	if args.Output != "table" && args.Output != "wide" {
+		return fmt.Errorf("--watch only supports the table and wide output formats")
+	}
+	if args.Interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	ticker := time.NewTicker(args.Interval)
+	defer ticker.Stop()
+
+	var previous map[string]*endpointRecord
+	var previousTime time.Time
+	for {
+		pollTime := time.Now()
+		pods, records, err := pollEndpoints(ctx, client, args)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		fmt.Print(clearScreen)
+		fmt.Printf("Every %s, %s\n\n", args.Interval, pollTime.Format(time.TimeOnly))
+		printWatchTables(pods, records, previous, pollTime.Sub(previousTime), args)
+
+		previous = map[string]*endpointRecord{}
+		for _, r := range records {
+			previous[r.key()] = r
+		}
+		previousTime = pollTime
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func pollEndpoints(ctx context.Context, client kube.CLIClient, args *EndpointsArgs) ([]string, []*endpointRecord, error) {
+	pollCtx, cancel := context.WithTimeout(ctx, args.CommandTimeout)
+	defer cancel()
+
+	pods, err := findPods(pollCtx, client, &args.PodSelectorArgs)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpointInfo, err := getEndpointInformation(pollCtx, pods, client, args.Concurrency)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sortedPodNames(endpointInfo), buildEndpointRecords(endpointInfo, args), nil
+}
+
+// key identifies a host of a cluster as seen by one pod across polls.
+func (r *endpointRecord) key() string {
+	return r.Pod + "|" + r.Cluster + "|" + r.Address + ":" + strconv.Itoa(r.Port)
+}
+
+func printWatchTables(pods []string, records []*endpointRecord, previous map[string]*endpointRecord, elapsed time.Duration, args *EndpointsArgs) {
+	wide := args.Output == "wide"
+	recordsByPod := map[string][]*endpointRecord{}
+	for _, record := range records {
+		recordsByPod[record.Pod] = append(recordsByPod[record.Pod], record)
+	}
+
+	for _, namespacePodName := range pods {
+		podRecords := recordsByPod[namespacePodName]
+		if len(podRecords) == 0 {
+			continue
+		}
+
+		headers := []interface{}{"Cluster", "Subset", "Endpoint", "Port", "Health", "Rq Success", "Rq Error", "Rq Timeout", "Cx Connect Fail", "Cx Active"}
+		if wide {
+			headers = append(headers, "Rq Total", "Rq Active", "Locality")
+		}
+		tbl := newTable(headers...)
+		for _, r := range podRecords {
+			last := previous[r.key()]
+			row := []interface{}{r.displayName(), r.Subset, r.Address, r.Port, r.healthCell(),
+				deltaCell(r, last, "rq_success", elapsed, false), deltaCell(r, last, "rq_error", elapsed, true),
+				deltaCell(r, last, "rq_timeout", elapsed, true), deltaCell(r, last, "cx_connect_fail", elapsed, true),
+				statCell(r, "cx_active")}
+			if wide {
+				row = append(row, deltaCell(r, last, "rq_total", elapsed, false), statCell(r, "rq_active"), r.Locality)
+			}
+			if !r.Healthy {
+				row = colorRow(row, color.FgRed)
+			}
+			tbl.AddRow(row...)
+		}
+		fmt.Printf("%s\n", namespacePodName)
+		tbl.Print()
+		fmt.Print("\n\n")
+	}
+}
+
+// deltaCell shows how much a counter grew since the previous poll and the rate per second. Counters that went
+// down were reset by a proxy restart, so the current value is the growth since then. Error counters that grew are
+// printed in red.
+func deltaCell(r *endpointRecord, last *endpointRecord, name string, elapsed time.Duration, isError bool) string {
+	if last == nil {
+		return "-"
+	}
+	delta := r.Stats[name] - last.Stats[name]
+	if delta < 0 {
+		delta = r.Stats[name]
+	}
+	if delta == 0 {
+		return ""
+	}
+	cell := fmt.Sprintf("+%d (%.1f/s)", delta, float64(delta)/elapsed.Seconds())
+	if isError {
+		return color.RedString(cell)
+	}
+	return cell
+}
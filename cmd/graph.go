@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/domain"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"github.com/prometheus/common/model"
+	"time"
+)
+
+// loadDependencyGraph builds the workload graph from the metric, with the metric value summed per edge.
+func loadDependencyGraph(api *prom.FakeAPI, metric string) (*domain.Graph, error) {
+	query := fmt.Sprintf("sum(%s) by (source_workload,source_workload_namespace,destination_workload,destination_workload_namespace,destination_service) > 0", metric)
+	output, _, err := api.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var edges []*domain.Edge
+	if vector, ok := output.(model.Vector); ok {
+		for _, sample := range vector {
+			m := sample.Metric
+			if m["source_workload"] == "" || m["destination_workload"] == "" {
+				continue
+			}
+			edges = append(edges, &domain.Edge{
+				Source:      domain.Workload{Name: string(m["source_workload"]), Namespace: string(m["source_workload_namespace"])},
+				Destination: domain.Workload{Name: string(m["destination_workload"]), Namespace: string(m["destination_workload_namespace"])},
+				Service:     string(m["destination_service"]),
+				Value:       float64(sample.Value),
+			})
+		}
+	}
+	return domain.NewGraph(edges), nil
+}
@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"fmt"
+	"istio.io/istio/pkg/kube"
+	"istio.io/istio/tools/bug-report/pkg/kubeclient"
+)
+
+// newCLIClient creates a kube client for the cluster selected by the global --context flag.
+func newCLIClient(globalFlags *GlobalFlags) (kube.CLIClient, error) {
+	restConfig, _, err := kubeclient.New(globalFlags.KubeConfigPath, globalFlags.KubeContext)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize k8s client: %s ", err)
+	}
+	client, err := kube.NewCLIClient(kube.NewClientConfigForRestConfig(restConfig))
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"os"
+	"sync"
+)
+
+// PodSelectorArgs select the pods a command inspects, by name, by workload, by Service or by label selector.
+type PodSelectorArgs struct {
+	PodName        string
+	DeploymentName string
+	StatefulSet    string
+	DaemonSet      string
+	Rollout        string
+	Service        string
+	Selector       string
+	Gateway        string
+	Namespace      string
+	AllNamespaces  bool
+}
+
+func addPodSelectorFlags(cmd *cobra.Command, args *PodSelectorArgs) {
+	cmd.Flags().StringVar(&args.PodName, "pod-name", "", "Name of a single pod")
+	cmd.Flags().StringVarP(&args.DeploymentName, "deployment-name", "d", "", "Name of deployment to select all of its pods")
+	cmd.Flags().StringVar(&args.StatefulSet, "statefulset", "", "Name of statefulset to select all of its pods")
+	cmd.Flags().StringVar(&args.DaemonSet, "daemonset", "", "Name of daemonset to select all of its pods")
+	cmd.Flags().StringVar(&args.Rollout, "rollout", "", "Name of Argo rollout to select all of its pods")
+	cmd.Flags().StringVar(&args.Service, "service", "", "Name of service whose selector picks the pods")
+	cmd.Flags().StringVarP(&args.Selector, "selector", "l", "", "Label selector of the pods, e.g. 'app=reviews,version!=v1'")
+	cmd.Flags().StringVarP(&args.Namespace, "namespace", "n", "", "Namespace of the pods")
+	cmd.Flags().BoolVarP(&args.AllNamespaces, "all-namespaces", "A", false, "Select pods in all namespaces")
+
+	cmd.MarkFlagsMutuallyExclusive("pod-name", "deployment-name", "statefulset", "daemonset", "rollout", "service", "selector")
+	cmd.MarkFlagsMutuallyExclusive("namespace", "all-namespaces")
+	cmd.MarkFlagsMutuallyExclusive("all-namespaces", "pod-name", "deployment-name", "statefulset", "daemonset", "rollout", "service")
+}
+
+func (args *PodSelectorArgs) validate() error {
+	if args.Namespace == "" && !args.AllNamespaces {
+		return errors.New(`required flag(s) "namespace" or "all-namespaces" not set`)
+	}
+	return nil
+}
+
+var rolloutResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+// Pod maps a pod name to its Pod info. The key is namespace/pod-name.
+func findPods(ctx context.Context, client kube.CLIClient, args *PodSelectorArgs) (map[string]*corev1.Pod, error) {
+	pods := map[string]*corev1.Pod{}
+	if args.PodName != "" {
+		pod, err := client.Kube().CoreV1().Pods(args.Namespace).Get(ctx, args.PodName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		pods[podNameNamespace(pod.Name, pod.Namespace)] = pod
+		fmt.Fprintln(os.Stderr, "found", len(pods), "pod(s)")
+		return pods, nil
+	}
+
+	namespace := args.Namespace
+	if args.AllNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+	// List the pods with the same labels as the selected workload
+	labelSelector, err := podLabelSelector(ctx, client, args)
+	if err != nil {
+		return nil, err
+	}
+	podsList, err := client.Kube().CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range podsList.Items {
+		pods[podNameNamespace(pod.Name, pod.Namespace)] = &pod
+	}
+	fmt.Fprintln(os.Stderr, "found", len(pods), "pod(s)")
+	return pods, nil
+}
+
+// podLabelSelector resolves the pod selector of the workload or Service picked with the flags. Without any of them
+// every pod of the namespace is selected.
+func podLabelSelector(ctx context.Context, client kube.CLIClient, args *PodSelectorArgs) (string, error) {
+	apps := client.Kube().AppsV1()
+	switch {
+	case args.DeploymentName != "":
+		deployment, err := apps.Deployments(args.Namespace).Get(ctx, args.DeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return metav1.FormatLabelSelector(deployment.Spec.Selector), nil
+	case args.StatefulSet != "":
+		statefulSet, err := apps.StatefulSets(args.Namespace).Get(ctx, args.StatefulSet, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return metav1.FormatLabelSelector(statefulSet.Spec.Selector), nil
+	case args.DaemonSet != "":
+		daemonSet, err := apps.DaemonSets(args.Namespace).Get(ctx, args.DaemonSet, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		return metav1.FormatLabelSelector(daemonSet.Spec.Selector), nil
+	case args.Rollout != "":
+		rollout, err := client.Dynamic().Resource(rolloutResource).Namespace(args.Namespace).Get(ctx, args.Rollout, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		spec, found, err := unstructured.NestedMap(rollout.Object, "spec", "selector")
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			// rollouts with a workloadRef take the selector of the referenced workload
+			kind, _, _ := unstructured.NestedString(rollout.Object, "spec", "workloadRef", "kind")
+			name, _, _ := unstructured.NestedString(rollout.Object, "spec", "workloadRef", "name")
+			switch kind {
+			case "Deployment":
+				deployment, err := apps.Deployments(args.Namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return "", err
+				}
+				return metav1.FormatLabelSelector(deployment.Spec.Selector), nil
+			case "ReplicaSet":
+				replicaSet, err := apps.ReplicaSets(args.Namespace).Get(ctx, name, metav1.GetOptions{})
+				if err != nil {
+					return "", err
+				}
+				return metav1.FormatLabelSelector(replicaSet.Spec.Selector), nil
+			case "":
+				return "", fmt.Errorf("rollout %s has no selector and no workloadRef", args.Rollout)
+			default:
+				return "", fmt.Errorf("rollout %s references a %s, only Deployment and ReplicaSet are supported", args.Rollout, kind)
+			}
+		}
+		var selector metav1.LabelSelector
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &selector); err != nil {
+			return "", fmt.Errorf("could not read the selector of rollout %s: %s", args.Rollout, err)
+		}
+		return metav1.FormatLabelSelector(&selector), nil
+	case args.Gateway != "":
+		namespace, name := parseGatewayFlag(args)
+		return gatewayPodSelector(ctx, client, namespace, name)
+	case args.Service != "":
+		service, err := client.Kube().CoreV1().Services(args.Namespace).Get(ctx, args.Service, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		if len(service.Spec.Selector) == 0 {
+			return "", fmt.Errorf("service %s has no pod selector", args.Service)
+		}
+		return labels.SelectorFromSet(service.Spec.Selector).String(), nil
+	}
+	return args.Selector, nil
+}
+
+// forEachPod runs fn for every pod with a bounded number of workers and prints the progress to stderr. The errors
+// are returned by pod.
+func forEachPod(names []string, concurrency int, action string, fn func(namespacePodName string) error) map[string]error {
+	podErrors := map[string]error{}
+	work := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var done int
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for namespacePodName := range work {
+				err := fn(namespacePodName)
+
+				mu.Lock()
+				if err != nil {
+					podErrors[namespacePodName] = err
+				}
+				done++
+				fmt.Fprintf(os.Stderr, "\r%s %d/%d pod(s)", action, done, len(names))
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, namespacePodName := range names {
+		work <- namespacePodName
+	}
+	close(work)
+	wg.Wait()
+	if len(names) > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+	return podErrors
+}
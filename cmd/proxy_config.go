@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"github.com/nmnellis/mesh-helper/internal/proxyconfig"
+	"github.com/spf13/cobra"
+	"istio.io/istio/istioctl/pkg/util/configdump"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type ProxyConfigArgs struct {
+	Namespace string
+	File      string
+	Output    string
+	URL       string
+}
+
+var proxyConfigTypes = []string{"listeners", "routes", "clusters", "secrets"}
+
+func proxyConfigCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
+	configArgs := &ProxyConfigArgs{}
+	cmd := &cobra.Command{
+		Use:     "proxy-config <pod> <listeners|routes|clusters|secrets>",
+		Aliases: []string{"pc"},
+		Short:   "Summarize the listeners, routes, clusters or secrets of a proxy",
+		Long: `Fetch the Envoy config_dump of a pod's istio-proxy and summarize one of its sections. With --file the
+config dump is read from a file and only the section is passed, e.g. mesh-helper proxy-config routes -f dump.json.
+
+With routes, --url shows which route of each route configuration a request to the URL would match. Header and
+query parameter conditions of the routes are not evaluated.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if configArgs.File != "" {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configArgs.File != "" {
+				return runProxyConfig(ctx, globalFlags, "", args[0], configArgs)
+			}
+			return runProxyConfig(ctx, globalFlags, args[0], args[1], configArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVarP(&configArgs.Namespace, "namespace", "n", "default", "Namespace of the pod")
+	cmd.Flags().StringVarP(&configArgs.File, "file", "f", "", "Read a saved Envoy config_dump instead of a pod")
+	cmd.Flags().StringVarP(&configArgs.Output, "output", "o", "table", "Output format (table, json)")
+	cmd.Flags().StringVar(&configArgs.URL, "url", "", "Show the route a request to the URL would match, e.g. http://reviews:9080/reviews/1")
+	return cmd
+}
+
+func runProxyConfig(ctx context.Context, globalFlags *GlobalFlags, podName string, configType string, args *ProxyConfigArgs) error {
+	if !slices.Contains(proxyConfigTypes, configType) {
+		return fmt.Errorf("unsupported config type %q, use one of %s", configType, strings.Join(proxyConfigTypes, ", "))
+	}
+	if args.URL != "" && configType != "routes" {
+		return fmt.Errorf("--url can only be used with routes")
+	}
+	if args.Output != "table" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+
+	dump, err := loadConfigDump(ctx, globalFlags, podName, args)
+	if err != nil {
+		return err
+	}
+
+	switch configType {
+	case "listeners":
+		listeners, err := proxyconfig.Listeners(dump)
+		if err != nil {
+			return err
+		}
+		return printProxyConfig(listeners, args, func() { printListeners(listeners) })
+	case "routes":
+		routeConfigs, err := proxyconfig.RouteConfigs(dump)
+		if err != nil {
+			return err
+		}
+		if args.URL == "" {
+			routes := proxyconfig.Routes(routeConfigs)
+			return printProxyConfig(routes, args, func() { printRoutes(routes, false) })
+		}
+		u, err := url.Parse(args.URL)
+		if err != nil {
+			return err
+		}
+		if u.Host == "" {
+			return fmt.Errorf("--url %q has no host, use e.g. http://reviews:9080/", args.URL)
+		}
+		path := u.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		port := u.Port()
+		if port == "" {
+			port = "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+		}
+		match, err := proxyconfig.MatchURL(routeConfigs, u.Hostname(), port, path)
+		if err != nil {
+			return err
+		}
+		routes := []*proxyconfig.Route{match}
+		return printProxyConfig(routes, args, func() { printRoutes(routes, true) })
+	case "clusters":
+		clusters, err := proxyconfig.Clusters(dump)
+		if err != nil {
+			return err
+		}
+		return printProxyConfig(clusters, args, func() { printClusters(clusters) })
+	default:
+		secrets, err := proxyconfig.Secrets(dump)
+		if err != nil {
+			return err
+		}
+		return printProxyConfig(secrets, args, func() { printSecrets(secrets) })
+	}
+}
+
+func loadConfigDump(ctx context.Context, globalFlags *GlobalFlags, podName string, args *ProxyConfigArgs) (*configdump.Wrapper, error) {
+	var data []byte
+	if args.File != "" {
+		var err error
+		data, err = os.ReadFile(args.File)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := disableIstioInfoLogging(); err != nil {
+			return nil, err
+		}
+		client, err := newCLIClient(globalFlags)
+		if err != nil {
+			return nil, err
+		}
+		pod, err := client.Kube().CoreV1().Pods(args.Namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if !containsProxyContainer(pod) {
+			return nil, fmt.Errorf("%s does not have an istio-proxy container", podNameNamespace(pod.Name, pod.Namespace))
+		}
+		response, err := envoyGet(ctx, pod, client, "config_dump")
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(response)
+	}
+
+	dump := &configdump.Wrapper{}
+	if err := dump.UnmarshalJSON(data); err != nil {
+		return nil, fmt.Errorf("could not parse the config dump: %s", err)
+	}
+	return dump, nil
+}
+
+func printProxyConfig(summary interface{}, args *ProxyConfigArgs, printTable func()) error {
+	if args.Output == "json" {
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printTable()
+	return nil
+}
+
+func printListeners(listeners []*proxyconfig.Listener) {
+	tbl := newTable("Name", "Address", "Port", "Match", "Destination")
+	for _, l := range listeners {
+		for _, fc := range l.FilterChains {
+			tbl.AddRow(l.Name, l.Address, l.Port, fc.Match, fc.Destination)
+		}
+	}
+	tbl.Print()
+}
+
+func printRoutes(routes []*proxyconfig.Route, matched bool) {
+	if matched && len(routes) == 0 {
+		fmt.Println("No route matches the URL")
+		return
+	}
+	headers := []interface{}{"Route Config", "Virtual Host", "Domains", "Match", "Action", "Virtual Service"}
+	if matched {
+		headers = append(headers, "Note")
+	}
+	tbl := newTable(headers...)
+	for _, r := range routes {
+		row := []interface{}{r.RouteConfig, r.VirtualHost, domainsCell(r.Domains), r.Match, r.Action, r.VirtualService}
+		if matched {
+			row = append(row, r.Note)
+		}
+		tbl.AddRow(row...)
+	}
+	tbl.Print()
+}
+
+// domainsCell keeps virtual hosts with many domains on one readable line.
+func domainsCell(domains []string) string {
+	if len(domains) > 2 {
+		return fmt.Sprintf("%s +%d more", strings.Join(domains[:2], ", "), len(domains)-2)
+	}
+	return strings.Join(domains, ", ")
+}
+
+func printClusters(clusters []*proxyconfig.Cluster) {
+	tbl := newTable("Service FQDN", "Port", "Subset", "Direction", "Type", "LB Policy", "TLS")
+	for _, c := range clusters {
+		name := envoy.ParseClusterName(c.Name)
+		port := ""
+		if name.Port != 0 {
+			port = fmt.Sprint(name.Port)
+		}
+		tbl.AddRow(name.FQDN, port, name.Subset, name.Direction, c.Type, c.LbPolicy, c.TLS)
+	}
+	tbl.Print()
+}
+
+func printSecrets(secrets []*proxyconfig.Secret) {
+	tbl := newTable("Resource Name", "Type", "Status", "Serial Number", "Not After", "Not Before", "Identities")
+	for _, s := range secrets {
+		notAfter := ""
+		if !s.NotAfter.IsZero() {
+			notAfter = s.NotAfter.Format(time.RFC3339)
+			if time.Now().After(s.NotAfter) {
+				notAfter = color.RedString(notAfter)
+			}
+		}
+		notBefore := ""
+		if !s.NotBefore.IsZero() {
+			notBefore = s.NotBefore.Format(time.RFC3339)
+		}
+		tbl.AddRow(s.Name, s.Type, s.Status, s.SerialNumber, notAfter, notBefore, strings.Join(s.Identities, ","))
+	}
+	tbl.Print()
+}
@@ -0,0 +1,438 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	"github.com/nmnellis/mesh-helper/internal/domain/istiod"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ProxyStatusArgs struct {
+	PodSelectorArgs
+	CommandTimeout time.Duration
+	Concurrency    int
+	Output         string
+	IstioNamespace string
+}
+
+const (
+	proxyDisconnected = "DISCONNECTED"
+	proxyStale        = "STALE"
+	proxyOutdated     = "OUTDATED"
+
+	// unknownVersion is reported for an istiod whose version could not be read, its proxies are not checked for skew.
+	unknownVersion = "unknown"
+)
+
+// istiodInstance is a running istiod pod and the revision and version it was deployed with.
+type istiodInstance struct {
+	Name     string `json:"name"`
+	Revision string `json:"revision"`
+	Version  string `json:"version,omitempty"`
+}
+
+// proxyStatusRecord is the version and control plane connection of one proxy.
+type proxyStatusRecord struct {
+	Pod           string   `json:"pod"`
+	Namespace     string   `json:"namespace"`
+	Type          string   `json:"type,omitempty"`
+	IstioVersion  string   `json:"istio_version,omitempty"`
+	EnvoyVersion  string   `json:"envoy_version,omitempty"`
+	Revision      string   `json:"revision"`
+	Istiod        string   `json:"istiod,omitempty"`
+	IstiodVersion string   `json:"istiod_version,omitempty"`
+	Connected     bool     `json:"connected"`
+	CDS           string   `json:"cds,omitempty"`
+	LDS           string   `json:"lds,omitempty"`
+	EDS           string   `json:"eds,omitempty"`
+	RDS           string   `json:"rds,omitempty"`
+	Problems      []string `json:"problems,omitempty"`
+}
+
+// proxySync is the sync state of a proxy in the debug/syncz of the istiod it is connected to.
+type proxySync struct {
+	Istiod string
+	Status *istiod.SyncStatus
+}
+
+// namespaceProxySummary counts the proxies of a namespace by version, revision and problem.
+type namespaceProxySummary struct {
+	Namespace    string         `json:"namespace"`
+	Proxies      int            `json:"proxies"`
+	Versions     map[string]int `json:"versions"`
+	Revisions    map[string]int `json:"revisions"`
+	Stale        int            `json:"stale"`
+	Disconnected int            `json:"disconnected"`
+	Outdated     int            `json:"outdated"`
+}
+
+func proxyStatusCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
+	statusArgs := &ProxyStatusArgs{}
+	cmd := &cobra.Command{
+		Use:   "proxy-status",
+		Short: "Report the version, istiod revision and xDS sync state of proxies",
+		Long: `Read the proxy version from the Envoy server_info endpoint and the control plane connection from its stats, and
+compare them with the debug/syncz state of every istiod. Proxies with unacknowledged xDS pushes are STALE, proxies
+not connected to any istiod are DISCONNECTED and proxies running another version than their istiod are OUTDATED.
+The istiod version is read from its version endpoint, or from its image tag when istiod cannot be reached. Proxies of
+an istiod with an unknown version are not checked for version skew.
+Without --namespace the proxies of all namespaces are reported.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runProxyStatus(ctx, globalFlags, statusArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	addPodSelectorFlags(cmd, &statusArgs.PodSelectorArgs)
+	cmd.Flags().DurationVarP(&statusArgs.CommandTimeout, "timeout", "t", time.Minute*5, "Timeout")
+	cmd.Flags().IntVar(&statusArgs.Concurrency, "concurrency", 10, "Number of pods to query in parallel")
+	cmd.Flags().StringVarP(&statusArgs.Output, "output", "o", "table", "Output format (table, json)")
+	cmd.Flags().StringVar(&statusArgs.IstioNamespace, "istio-namespace", "istio-system", "Namespace of the istiod deployments")
+	return cmd
+}
+
+func runProxyStatus(ctx context.Context, globalFlags *GlobalFlags, args *ProxyStatusArgs) error {
+	selectsWorkload := args.PodName != "" || args.DeploymentName != "" || args.StatefulSet != "" ||
+		args.DaemonSet != "" || args.Rollout != "" || args.Service != ""
+	if args.Namespace == "" && !selectsWorkload {
+		args.AllNamespaces = true
+	}
+	if err := args.PodSelectorArgs.validate(); err != nil {
+		return err
+	}
+	if args.Output != "table" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+	if args.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if err := disableIstioInfoLogging(); err != nil {
+		return err
+	}
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, args.CommandTimeout)
+	defer cancel()
+
+	istiods, err := getIstiodInstances(ctx, client, args.IstioNamespace)
+	if err != nil {
+		return err
+	}
+	syncStatuses, err := getSyncStatuses(ctx, client, args.IstioNamespace)
+	if err != nil {
+		// the proxies can still be reported from their own admin endpoints
+		fmt.Fprintf(os.Stderr, "could not read debug/syncz from istiod: %s\n", err)
+	}
+
+	pods, err := findPods(ctx, client, &args.PodSelectorArgs)
+	if err != nil {
+		return err
+	}
+	var proxyPods []string
+	for _, namespacePodName := range sortedKeys(pods) {
+		if containsProxyContainer(pods[namespacePodName]) {
+			proxyPods = append(proxyPods, namespacePodName)
+		}
+	}
+	if skipped := len(pods) - len(proxyPods); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d pod(s) without an istio-proxy container\n", skipped)
+	}
+
+	var records []*proxyStatusRecord
+	var mu sync.Mutex
+	podErrors := forEachPod(proxyPods, args.Concurrency, "reading proxy status", func(namespacePodName string) error {
+		pod := pods[namespacePodName]
+		response, err := envoyGet(ctx, pod, client, "server_info")
+		if err != nil {
+			return err
+		}
+		var serverInfo envoy.ServerInfo
+		if err := json.Unmarshal([]byte(response), &serverInfo); err != nil {
+			return err
+		}
+		stats, err := envoyGet(ctx, pod, client, "stats?filter=^control_plane.connected_state$")
+		if err != nil {
+			return err
+		}
+
+		record := buildProxyStatusRecord(namespacePodName, pod, &serverInfo, stats, istiods, syncStatuses)
+		mu.Lock()
+		records = append(records, record)
+		mu.Unlock()
+		return nil
+	})
+	printPodErrors(podErrors)
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Pod < records[j].Pod
+	})
+	summaries := summarizeProxyStatus(records)
+	if args.Output == "json" {
+		if records == nil {
+			records = []*proxyStatusRecord{}
+		}
+		data, err := json.MarshalIndent(map[string]interface{}{
+			"istiods":    istiods,
+			"proxies":    records,
+			"namespaces": summaries,
+		}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printProxyStatusTables(records, summaries, istiods)
+	return nil
+}
+
+// getIstiodInstances lists the running istiod pods. The version is read from the version endpoint of istiod and
+// falls back to the tag of the discovery image when istiod cannot be reached.
+func getIstiodInstances(ctx context.Context, client kube.CLIClient, namespace string) ([]*istiodInstance, error) {
+	pods, err := client.Kube().CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "app=istiod",
+		FieldSelector: kube.RunningStatus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var istiods []*istiodInstance
+	for _, pod := range pods.Items {
+		instance := &istiodInstance{Name: pod.Name, Revision: podRevision(&pod)}
+		response, err := client.EnvoyDoWithPort(ctx, pod.Name, pod.Namespace, "GET", "version", kube.FindIstiodMonitoringPort(&pod))
+		if err == nil {
+			instance.Version = istiodVersion(string(response))
+		}
+		if instance.Version == "" {
+			for _, container := range pod.Spec.Containers {
+				if container.Name == "discovery" {
+					instance.Version = imageVersion(container.Image)
+				}
+			}
+		}
+		if instance.Version == "" {
+			fmt.Fprintf(os.Stderr, "could not determine the version of %s, its proxies are not checked for version skew\n", pod.Name)
+			instance.Version = unknownVersion
+		}
+		istiods = append(istiods, instance)
+	}
+	sort.Slice(istiods, func(i, j int) bool {
+		return istiods[i].Name < istiods[j].Name
+	})
+	return istiods, nil
+}
+
+// getSyncStatuses reads debug/syncz from every istiod and maps the proxy id, <pod>.<namespace>, to the istiod it is
+// connected to and its sync state.
+func getSyncStatuses(ctx context.Context, client kube.CLIClient, namespace string) (map[string]*proxySync, error) {
+	responses, err := client.AllDiscoveryDo(ctx, namespace, "debug/syncz")
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[string]*proxySync{}
+	for istiodName, response := range responses {
+		var syncz []*istiod.SyncStatus
+		if err := json.Unmarshal(response, &syncz); err != nil {
+			return nil, fmt.Errorf("could not parse debug/syncz of %s: %s", istiodName, err)
+		}
+		for _, status := range syncz {
+			statuses[status.ProxyID] = &proxySync{Istiod: istiodName, Status: status}
+		}
+	}
+	return statuses, nil
+}
+
+func buildProxyStatusRecord(namespacePodName string, pod *corev1.Pod, serverInfo *envoy.ServerInfo, stats string, istiods []*istiodInstance, syncStatuses map[string]*proxySync) *proxyStatusRecord {
+	record := &proxyStatusRecord{
+		Pod:          namespacePodName,
+		Namespace:    pod.Namespace,
+		Type:         serverInfo.ProxyType(),
+		IstioVersion: serverInfo.IstioVersion(),
+		EnvoyVersion: serverInfo.EnvoyVersion(),
+		Revision:     podRevision(pod),
+		Connected:    strings.Contains(stats, "control_plane.connected_state: 1"),
+	}
+
+	// without debug/syncz the proxy's own connected_state is all there is to go by
+	synced, known := syncStatuses[pod.Name+"."+pod.Namespace]
+	if syncStatuses != nil && !known {
+		record.Connected = false
+	}
+	if known {
+		record.Istiod = synced.Istiod
+		record.CDS = istiod.XDSStatus(synced.Status.ClusterSent, synced.Status.ClusterAcked)
+		record.LDS = istiod.XDSStatus(synced.Status.ListenerSent, synced.Status.ListenerAcked)
+		record.EDS = istiod.XDSStatus(synced.Status.EndpointSent, synced.Status.EndpointAcked)
+		record.RDS = istiod.XDSStatus(synced.Status.RouteSent, synced.Status.RouteAcked)
+	}
+	for _, instance := range istiods {
+		if instance.Name == record.Istiod || (record.Istiod == "" && instance.Revision == record.Revision) {
+			record.IstiodVersion = instance.Version
+			break
+		}
+	}
+
+	if !record.Connected {
+		record.Problems = append(record.Problems, proxyDisconnected)
+	}
+	if known && synced.Status.IsStale() {
+		record.Problems = append(record.Problems, proxyStale)
+	}
+	if record.IstiodVersion != "" && record.IstiodVersion != unknownVersion && record.IstioVersion != "" &&
+		record.IstioVersion != record.IstiodVersion {
+		record.Problems = append(record.Problems, proxyOutdated)
+	}
+	return record
+}
+
+// podRevision returns the istio.io/rev label the injector adds to the pods of a revision.
+func podRevision(pod *corev1.Pod) string {
+	if revision := pod.Labels["istio.io/rev"]; revision != "" {
+		return revision
+	}
+	return "default"
+}
+
+// istiodVersion returns the version of the istiod version endpoint response, 1.25.0-<git revision>-Clean, without
+// the build information.
+func istiodVersion(response string) string {
+	response = strings.TrimSpace(response)
+	if strings.ContainsAny(response, " \n") {
+		// not a version, such as an error page
+		return ""
+	}
+	parts := strings.Split(response, "-")
+	if len(parts) < 3 {
+		return strings.Join(parts, "-")
+	}
+	// a dirty build is <version>-<git revision>-dirty-<status>
+	if parts[len(parts)-2] == "dirty" && len(parts) > 3 {
+		return strings.Join(parts[:len(parts)-3], "-")
+	}
+	return strings.Join(parts[:len(parts)-2], "-")
+}
+
+// imageVersion returns the tag of an image such as docker.io/istio/pilot:1.25.0-distroless without its variant.
+func imageVersion(image string) string {
+	if strings.Contains(image, "@") {
+		return ""
+	}
+	slash := strings.LastIndex(image, "/")
+	colon := strings.LastIndex(image, ":")
+	if colon <= slash {
+		return ""
+	}
+	tag := image[colon+1:]
+	for _, variant := range []string{"-distroless", "-debug"} {
+		tag = strings.TrimSuffix(tag, variant)
+	}
+	return tag
+}
+
+func summarizeProxyStatus(records []*proxyStatusRecord) []*namespaceProxySummary {
+	byNamespace := map[string]*namespaceProxySummary{}
+	var summaries []*namespaceProxySummary
+	for _, r := range records {
+		summary, ok := byNamespace[r.Namespace]
+		if !ok {
+			summary = &namespaceProxySummary{Namespace: r.Namespace, Versions: map[string]int{}, Revisions: map[string]int{}}
+			byNamespace[r.Namespace] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Proxies++
+		version := r.IstioVersion
+		if version == "" {
+			version = "unknown"
+		}
+		summary.Versions[version]++
+		summary.Revisions[r.Revision]++
+		for _, problem := range r.Problems {
+			switch problem {
+			case proxyStale:
+				summary.Stale++
+			case proxyDisconnected:
+				summary.Disconnected++
+			case proxyOutdated:
+				summary.Outdated++
+			}
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Namespace < summaries[j].Namespace
+	})
+	return summaries
+}
+
+func printProxyStatusTables(records []*proxyStatusRecord, summaries []*namespaceProxySummary, istiods []*istiodInstance) {
+	tbl := newTable("Istiod", "Revision", "Version")
+	for _, instance := range istiods {
+		tbl.AddRow(instance.Name, instance.Revision, instance.Version)
+	}
+	tbl.Print()
+	fmt.Println()
+
+	tbl = newTable("Pod", "Type", "Istio Version", "Envoy Version", "Revision", "Istiod", "CDS", "LDS", "EDS", "RDS", "Status")
+	var flagged int
+	for _, r := range records {
+		status := "OK"
+		if len(r.Problems) > 0 {
+			flagged++
+			status = color.RedString(strings.Join(r.Problems, ","))
+		}
+		tbl.AddRow(r.Pod, r.Type, r.IstioVersion, r.EnvoyVersion, r.Revision, r.Istiod,
+			xdsCell(r.CDS), xdsCell(r.LDS), xdsCell(r.EDS), xdsCell(r.RDS), status)
+	}
+	tbl.Print()
+	fmt.Println()
+
+	tbl = newTable("Namespace", "Proxies", "Versions", "Revisions", "Stale", "Disconnected", "Outdated")
+	for _, s := range summaries {
+		tbl.AddRow(s.Namespace, s.Proxies, countsCell(s.Versions), countsCell(s.Revisions),
+			problemCount(s.Stale), problemCount(s.Disconnected), problemCount(s.Outdated))
+	}
+	tbl.Print()
+	fmt.Printf("\n%d of %d proxies flagged\n", flagged, len(records))
+}
+
+func xdsCell(status string) string {
+	if status == istiod.Stale {
+		return color.RedString(status)
+	}
+	return status
+}
+
+func problemCount(count int) string {
+	if count > 0 {
+		return color.RedString(fmt.Sprint(count))
+	}
+	return "0"
+}
+
+// countsCell prints counts such as 1.24.2 (3), 1.25.0 (1) sorted by key.
+func countsCell(counts map[string]int) string {
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var cells []string
+	for _, key := range keys {
+		cells = append(cells, fmt.Sprintf("%s (%d)", key, counts[key]))
+	}
+	return strings.Join(cells, ", ")
+}
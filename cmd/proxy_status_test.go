@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"github.com/nmnellis/mesh-helper/internal/domain/envoy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestImageVersion(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "docker.io/istio/pilot:1.25.0", want: "1.25.0"},
+		{image: "docker.io/istio/pilot:1.25.0-distroless", want: "1.25.0"},
+		{image: "gcr.io/istio-release/pilot:1.24.2-debug", want: "1.24.2"},
+		{image: "localhost:5000/istio/pilot:1.25.0", want: "1.25.0"},
+		{image: "localhost:5000/istio/pilot", want: ""},
+		{image: "docker.io/istio/pilot@sha256:0123456789abcdef", want: ""},
+		{image: "docker.io/istio/pilot:1.25.0@sha256:0123456789abcdef", want: ""},
+	}
+	for _, tt := range tests {
+		if got := imageVersion(tt.image); got != tt.want {
+			t.Errorf("imageVersion(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestIstiodVersion(t *testing.T) {
+	tests := []struct {
+		response string
+		want     string
+	}{
+		{response: "1.25.0-0f67335414a5-Clean\n", want: "1.25.0"},
+		{response: "1.25.0-custom-build-0f67335414a5-Modified", want: "1.25.0-custom-build"},
+		{response: "1.12.0-016bc46f4a5e-dirty-Modified", want: "1.12.0"},
+		{response: "1.25.0", want: "1.25.0"},
+		{response: "", want: ""},
+		{response: "404 page not found", want: ""},
+	}
+	for _, tt := range tests {
+		if got := istiodVersion(tt.response); got != tt.want {
+			t.Errorf("istiodVersion(%q) = %q, want %q", tt.response, got, tt.want)
+		}
+	}
+}
+
+func TestBuildProxyStatusRecordVersionSkew(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "reviews-v1", Namespace: "default"}}
+	serverInfo := &envoy.ServerInfo{Node: envoy.Node{Metadata: map[string]interface{}{"ISTIO_VERSION": "1.24.2"}}}
+	stats := "control_plane.connected_state: 1"
+
+	tests := []struct {
+		name    string
+		version string
+		want    []string
+	}{
+		{name: "same version", version: "1.24.2"},
+		{name: "other version", version: "1.25.0", want: []string{proxyOutdated}},
+		{name: "unknown version", version: unknownVersion},
+	}
+	for _, tt := range tests {
+		istiods := []*istiodInstance{{Name: "istiod-1", Revision: "default", Version: tt.version}}
+		record := buildProxyStatusRecord("default/reviews-v1", pod, serverInfo, stats, istiods, nil)
+		if record.IstiodVersion != tt.version {
+			t.Errorf("%s: istiod version = %q, want %q", tt.name, record.IstiodVersion, tt.version)
+		}
+		if len(record.Problems) != len(tt.want) || (len(tt.want) > 0 && record.Problems[0] != tt.want[0]) {
+			t.Errorf("%s: problems = %v, want %v", tt.name, record.Problems, tt.want)
+		}
+	}
+}
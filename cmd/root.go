@@ -31,8 +31,16 @@ func RootCommand(ctx context.Context) *cobra.Command {
 	globalFlags.AddToFlags(cmd.PersistentFlags())
 
 	cmd.AddCommand(
-		dependenciesCmd(),
+		dependenciesCmd(ctx, globalFlags),
+		authzSimulateCmd(),
 		endpointsCmd(ctx, globalFlags),
+		proxyConfigCmd(ctx, globalFlags),
+		certsCmd(ctx, globalFlags),
+		proxyStatusCmd(ctx, globalFlags),
+		unusedCmd(ctx, globalFlags),
+		blastRadiusCmd(),
+		captureCmd(ctx, globalFlags),
+		serveCmd(ctx),
 	)
 
 	return cmd
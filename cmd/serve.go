@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/domain"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"github.com/spf13/cobra"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+type ServeArgs struct {
+	Addr    string
+	File    string
+	PromURL string
+	Metric  string
+	Window  time.Duration
+	Refresh time.Duration
+}
+
+// meshServer keeps the loaded metrics and the dependency graph built from them. Handlers hold the read lock while
+// they query the storage, a refresh swaps both under the write lock.
+type meshServer struct {
+	args   *ServeArgs
+	mu     sync.RWMutex
+	api    *prom.FakeAPI
+	graph  *domain.Graph
+	loaded time.Time
+}
+
+func serveCmd(ctx context.Context) *cobra.Command {
+	serveArgs := &ServeArgs{}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the dependency graph and generated policies over HTTP with a web UI",
+		Long: `Load the metrics from a file or prometheus and serve them until interrupted. Metrics from prometheus are
+reloaded every --refresh.
+
+  /                                              interactive dependency graph
+  /api/graph?namespace=                          workloads and the edges between them
+  /api/workloads/{namespace}/{name}/dependencies callers and callees of a workload
+  /api/policies/authz?namespace=                 generated AuthorizationPolicies`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(ctx, serveArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVar(&serveArgs.Addr, "addr", ":8080", "Address to listen on")
+	cmd.Flags().StringVarP(&serveArgs.File, "file", "f", "", "Read from a prometheus formatted input file")
+	cmd.Flags().StringVar(&serveArgs.PromURL, "prom-url", "", "Call prometheus directly to fetch data")
+	cmd.Flags().StringVar(&serveArgs.Metric, "metric", "istio_tcp_sent_bytes_total", "Metric to grab dependency tree (istio_tcp_sent_bytes_total, istio_requests_total)")
+	cmd.Flags().DurationVar(&serveArgs.Window, "window", 0, "Only count the traffic of the last window, requires --prom-url")
+	cmd.Flags().DurationVar(&serveArgs.Refresh, "refresh", 5*time.Minute, "Time between reloads of the metrics from --prom-url")
+	cmd.MarkFlagsMutuallyExclusive("file", "prom-url")
+	return cmd
+}
+
+func runServe(ctx context.Context, args *ServeArgs) error {
+	if args.PromURL != "" && args.Refresh <= 0 {
+		return errors.New("--refresh must be positive")
+	}
+	server := &meshServer{args: args}
+	if err := server.load(); err != nil {
+		return err
+	}
+
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /", http.FileServer(http.FS(static)))
+	mux.HandleFunc("GET /api/graph", server.handleGraph)
+	mux.HandleFunc("GET /api/workloads/{namespace}/{name}/dependencies", server.handleDependencies)
+	mux.HandleFunc("GET /api/policies/authz", server.handleAuthz)
+	httpServer := &http.Server{Addr: args.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if args.PromURL != "" {
+		go server.refreshEvery(ctx, args.Refresh)
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving the dependencies of %s on %s\n", args.Metric, args.Addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// load reads the metrics again and swaps them with the previous ones.
+func (s *meshServer) load() error {
+	api, err := loadPromAPIWindow(s.args.File, s.args.PromURL, s.args.Metric, s.args.Window)
+	if err != nil {
+		return err
+	}
+	graph, err := loadDependencyGraph(api, s.args.Metric)
+	if err != nil {
+		api.Storage.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	previous := s.api
+	s.api, s.graph, s.loaded = api, graph, time.Now()
+	if previous != nil {
+		previous.Storage.Close()
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *meshServer) refreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// keep serving the previous metrics when prometheus can't be reached
+			if err := s.load(); err != nil {
+				fmt.Fprintf(os.Stderr, "could not refresh the metrics: %s\n", err)
+			}
+		}
+	}
+}
+
+func (s *meshServer) handleGraph(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	graph := filterGraph(s.graph, r.URL.Query().Get("namespace"), "")
+	workloads, edges := graph.Workloads(), graph.Edges
+	if workloads == nil {
+		workloads = []domain.Workload{}
+	}
+	if edges == nil {
+		edges = []*domain.Edge{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"metric":    s.args.Metric,
+		"loaded":    s.loaded,
+		"workloads": workloads,
+		"edges":     edges,
+	})
+}
+
+func (s *meshServer) handleDependencies(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	workload := domain.Workload{Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}
+	callers, callees := s.graph.Callers(workload), s.graph.Callees(workload)
+	if len(callers) == 0 && len(callees) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("workload %s has no traffic in %s", workload, s.args.Metric)})
+		return
+	}
+	if callers == nil {
+		callers = []*domain.Edge{}
+	}
+	if callees == nil {
+		callees = []*domain.Edge{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workload": workload,
+		"callers":  callers,
+		"callees":  callees,
+	})
+}
+
+func (s *meshServer) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	namespace := r.URL.Query().Get("namespace")
+	sourceToDestMap, err := mapSourcesToDestinations(s.api, namespace, "", s.args.Metric)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	policies, err := generateIstioAuthZPolicies(sourceToDestMap, s.api, namespace, s.args.Metric)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if policies == nil {
+		writeJSON(w, http.StatusOK, []interface{}{})
+		return
+	}
+	writeJSON(w, http.StatusOK, policies)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"github.com/fatih/color"
+	"github.com/rodaine/table"
+	"regexp"
+	"unicode/utf8"
+)
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// newTable creates a table with the header and first column colors used across mesh-helper. Cells may contain
+// colored text, the column widths ignore the color escape codes.
+func newTable(columnHeaders ...interface{}) table.Table {
+	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
+	columnFmt := color.New(color.FgYellow).SprintfFunc()
+
+	tbl := table.New(columnHeaders...)
+	tbl.WithHeaderFormatter(headerFmt).WithFirstColumnFormatter(columnFmt).WithWidthFunc(visibleWidth)
+	return tbl
+}
+
+func visibleWidth(s string) int {
+	return utf8.RuneCountInString(ansiEscape.ReplaceAllString(s, ""))
+}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nmnellis/mesh-helper/internal/prom"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"istio.io/istio/pkg/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type UnusedArgs struct {
+	File              string
+	PromURL           string
+	Metrics           []string
+	Window            time.Duration
+	Namespace         string
+	ExcludeNamespaces []string
+	Output            string
+	CommandTimeout    time.Duration
+}
+
+// unusedRecord is a Deployment that received no traffic or a Service nobody called.
+type unusedRecord struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	Replicas  *int32    `json:"replicas,omitempty"`
+	// SendsTraffic is set for Deployments that made requests themselves, e.g. gateways, jobs or clients of external
+	// services.
+	SendsTraffic bool `json:"sends_traffic"`
+	// InMesh is false when the pods are not injected or enrolled in ambient, the metrics can't show their traffic.
+	InMesh bool `json:"in_mesh"`
+}
+
+func unusedCmd(ctx context.Context, globalFlags *GlobalFlags) *cobra.Command {
+	unusedArgs := &UnusedArgs{}
+	cmd := &cobra.Command{
+		Use:   "unused",
+		Short: "List Deployments without inbound traffic and Services without callers",
+		Long: `Join the Deployments and Services in the cluster with the Istio metrics and report the Deployments that did not
+receive any traffic and the Services nobody called. With --prom-url and --window only the traffic of the last window
+counts, e.g. --window 168h for a week, otherwise every request recorded by the counters does.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUnused(ctx, globalFlags, unusedArgs)
+		},
+		CompletionOptions: cobra.CompletionOptions{
+			DisableDefaultCmd: true,
+		},
+		SilenceUsage: true,
+	}
+	cmd.Flags().StringVarP(&unusedArgs.File, "file", "f", "", "Read from a prometheus formatted input file")
+	cmd.Flags().StringVar(&unusedArgs.PromURL, "prom-url", "", "Call prometheus directly to fetch data")
+	cmd.Flags().StringSliceVar(&unusedArgs.Metrics, "metric", []string{"istio_requests_total", "istio_tcp_sent_bytes_total"}, "Metrics counting the traffic between workloads")
+	cmd.Flags().DurationVar(&unusedArgs.Window, "window", 0, "Only count the traffic of the last window, requires --prom-url")
+	cmd.Flags().StringVarP(&unusedArgs.Namespace, "namespace", "n", "", "Only report the workloads of this namespace")
+	cmd.Flags().StringSliceVar(&unusedArgs.ExcludeNamespaces, "exclude-namespaces", []string{"kube-system", "kube-public", "kube-node-lease"}, "Namespaces that are never reported")
+	cmd.Flags().StringVarP(&unusedArgs.Output, "output", "o", "table", "Output format (table, json)")
+	cmd.Flags().DurationVarP(&unusedArgs.CommandTimeout, "timeout", "t", time.Minute*5, "Timeout")
+	cmd.MarkFlagsMutuallyExclusive("file", "prom-url")
+	return cmd
+}
+
+func runUnused(ctx context.Context, globalFlags *GlobalFlags, args *UnusedArgs) error {
+	if args.Output != "table" && args.Output != "json" {
+		return fmt.Errorf("unsupported output format %q", args.Output)
+	}
+	if len(args.Metrics) == 0 {
+		return errors.New("at least one --metric is required")
+	}
+
+	traffic := &workloadTraffic{
+		receivers: map[string]bool{},
+		senders:   map[string]bool{},
+		services:  map[string]bool{},
+	}
+	for _, metric := range args.Metrics {
+		api, err := loadPromAPIWindow(args.File, args.PromURL, metric, args.Window)
+		if err != nil {
+			return err
+		}
+		if err := traffic.add(api, metric); err != nil {
+			return err
+		}
+	}
+
+	if err := disableIstioInfoLogging(); err != nil {
+		return err
+	}
+	client, err := newCLIClient(globalFlags)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, args.CommandTimeout)
+	defer cancel()
+
+	records, err := findUnused(ctx, client, traffic, args)
+	if err != nil {
+		return err
+	}
+	if args.Output == "json" {
+		if records == nil {
+			records = []*unusedRecord{}
+		}
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printUnusedTable(records, args)
+	return nil
+}
+
+// workloadTraffic holds the namespace/name of the workloads and Services seen in the metrics.
+type workloadTraffic struct {
+	receivers map[string]bool
+	senders   map[string]bool
+	services  map[string]bool
+}
+
+func (t *workloadTraffic) add(api *prom.FakeAPI, metric string) error {
+	for _, q := range []struct {
+		set       map[string]bool
+		name      model.LabelName
+		namespace model.LabelName
+	}{
+		{t.receivers, "destination_workload", "destination_workload_namespace"},
+		{t.senders, "source_workload", "source_workload_namespace"},
+		{t.services, "destination_service_name", "destination_service_namespace"},
+	} {
+		query := fmt.Sprintf("sum(%s) by (%s,%s) > 0", metric, q.name, q.namespace)
+		err := queryLabelValues(api, query, func(m model.Metric) {
+			name, namespace := m[q.name], m[q.namespace]
+			if name != "" && name != "unknown" {
+				q.set[string(namespace)+"/"+string(name)] = true
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// some pipelines drop destination_service_name and only keep the FQDN, e.g. reviews.default.svc.cluster.local
+	return queryLabelValues(api, fmt.Sprintf("sum(%s) by (destination_service) > 0", metric), func(m model.Metric) {
+		parts := strings.Split(string(m["destination_service"]), ".")
+		if len(parts) > 2 && parts[2] == "svc" {
+			t.services[parts[1]+"/"+parts[0]] = true
+		}
+	})
+}
+
+func queryLabelValues(api *prom.FakeAPI, query string, fn func(model.Metric)) error {
+	output, _, err := api.Query(context.Background(), query, time.Now())
+	if err != nil {
+		return err
+	}
+	if vector, ok := output.(model.Vector); ok {
+		for _, sample := range vector {
+			fn(sample.Metric)
+		}
+	}
+	return nil
+}
+
+func findUnused(ctx context.Context, client kube.CLIClient, traffic *workloadTraffic, args *UnusedArgs) ([]*unusedRecord, error) {
+	namespace := args.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceAll
+	}
+	namespaces, err := client.Kube().CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	meshNamespaces := map[string]bool{}
+	for _, ns := range namespaces.Items {
+		meshNamespaces[ns.Name] = namespaceInMesh(ns.Labels)
+	}
+
+	var records []*unusedRecord
+	deployments, err := client.Kube().AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		key := deployment.Namespace + "/" + deployment.Name
+		if slices.Contains(args.ExcludeNamespaces, deployment.Namespace) || traffic.receivers[key] {
+			continue
+		}
+		records = append(records, &unusedRecord{
+			Kind:         "Deployment",
+			Namespace:    deployment.Namespace,
+			Name:         deployment.Name,
+			Created:      deployment.CreationTimestamp.Time,
+			Replicas:     deployment.Spec.Replicas,
+			SendsTraffic: traffic.senders[key],
+			InMesh:       templateInMesh(meshNamespaces[deployment.Namespace], &deployment.Spec.Template),
+		})
+	}
+
+	services, err := client.Kube().CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		key := service.Namespace + "/" + service.Name
+		// the API server and external names are not reached through the mesh
+		if slices.Contains(args.ExcludeNamespaces, service.Namespace) || traffic.services[key] ||
+			key == "default/kubernetes" || service.Spec.Type == corev1.ServiceTypeExternalName {
+			continue
+		}
+		records = append(records, &unusedRecord{
+			Kind:      "Service",
+			Namespace: service.Namespace,
+			Name:      service.Name,
+			Created:   service.CreationTimestamp.Time,
+			InMesh:    meshNamespaces[service.Namespace],
+		})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return records, nil
+}
+
+// namespaceInMesh checks the sidecar injection and ambient labels of a namespace.
+func namespaceInMesh(labels map[string]string) bool {
+	return labels["istio-injection"] == "enabled" || labels["istio.io/rev"] != "" ||
+		labels["istio.io/dataplane-mode"] == "ambient"
+}
+
+// templateInMesh applies the pod level injection and ambient labels on top of the namespace ones.
+func templateInMesh(namespaceInMesh bool, template *corev1.PodTemplateSpec) bool {
+	switch {
+	case template.Labels["sidecar.istio.io/inject"] == "false" || template.Labels["istio.io/dataplane-mode"] == "none":
+		return false
+	case template.Labels["sidecar.istio.io/inject"] == "true" || template.Labels["istio.io/rev"] != "" ||
+		template.Labels["istio.io/dataplane-mode"] == "ambient":
+		return true
+	}
+	return namespaceInMesh && template.Annotations["sidecar.istio.io/inject"] != "false"
+}
+
+func printUnusedTable(records []*unusedRecord, args *UnusedArgs) {
+	tbl := newTable("Kind", "Namespace", "Name", "Age", "Replicas", "Sends Traffic", "In Mesh")
+	var deployments, services, outsideMesh int
+	for _, r := range records {
+		replicas := ""
+		sends := ""
+		if r.Kind == "Deployment" {
+			deployments++
+			replicas = "1"
+			if r.Replicas != nil {
+				replicas = fmt.Sprint(*r.Replicas)
+			}
+			sends = yesNo(r.SendsTraffic)
+		} else {
+			services++
+		}
+		if !r.InMesh {
+			outsideMesh++
+		}
+		tbl.AddRow(r.Kind, r.Namespace, r.Name, duration.HumanDuration(time.Since(r.Created)), replicas, sends, yesNo(r.InMesh))
+	}
+	tbl.Print()
+
+	window := "all recorded traffic"
+	if args.Window > 0 {
+		window = "the last " + model.Duration(args.Window).String()
+	}
+	fmt.Printf("\n%d deployment(s) without inbound traffic and %d service(s) without callers in %s of %s\n",
+		deployments, services, window, strings.Join(args.Metrics, ", "))
+	if outsideMesh > 0 {
+		fmt.Printf("%d of them are not in the mesh, their traffic is not in the metrics\n", outsideMesh)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,325 @@
+package authz
+
+import (
+	"fmt"
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	"istio.io/client-go/pkg/apis/security/v1beta1"
+	"strings"
+)
+
+const (
+	ActionAllow  = "ALLOW"
+	ActionDeny   = "DENY"
+	ActionCustom = "CUSTOM"
+	ActionAudit  = "AUDIT"
+)
+
+// Request is a single observed call between two workloads. Fields that were not captured in the traffic data are
+// left empty and evaluated the way Istio treats HTTP-only fields on TCP traffic.
+type Request struct {
+	SourceWorkload       string
+	SourcePrincipal      string
+	SourceNamespace      string
+	DestinationWorkload  string
+	DestinationNamespace string
+	DestinationLabels    map[string]string
+	Port                 string
+	HTTP                 bool
+	Method               string
+	Path                 string
+	Host                 string
+	// InferredLabels are the destination labels guessed rather than observed, a selector on a different value of
+	// such a label can not be ruled out.
+	InferredLabels map[string]bool
+}
+
+// Decision is the outcome of replaying a Request against a set of AuthorizationPolicies.
+type Decision struct {
+	Action  string
+	Policy  string
+	Reason  string
+	Audited bool
+	// Custom is the extension provider that would be consulted before the decision is made.
+	Custom string
+	// Unevaluated are the policies in scope whose selector uses labels the request does not carry, the decision
+	// does not take them into account.
+	Unevaluated []string
+}
+
+func (d *Decision) Blocked() bool {
+	return d.Action == ActionDeny
+}
+
+// Uncertain reports whether a policy could not be evaluated, which may change the decision.
+func (d *Decision) Uncertain() bool {
+	return len(d.Unevaluated) > 0
+}
+
+// Simulator evaluates requests with Istio's CUSTOM, DENY, ALLOW order. AUDIT policies never change the outcome.
+type Simulator struct {
+	RootNamespace string
+	Policies      []*v1beta1.AuthorizationPolicy
+}
+
+func NewSimulator(rootNamespace string, policies []*v1beta1.AuthorizationPolicy) *Simulator {
+	return &Simulator{
+		RootNamespace: rootNamespace,
+		Policies:      policies,
+	}
+}
+
+func (s *Simulator) Evaluate(req *Request) *Decision {
+	policies, unevaluated := s.applicablePolicies(req)
+	decision := &Decision{}
+	for _, policy := range unevaluated {
+		decision.Unevaluated = append(decision.Unevaluated, policyName(policy))
+	}
+	byAction := map[string][]*v1beta1.AuthorizationPolicy{}
+	for _, policy := range policies {
+		action := actionName(policy)
+		byAction[action] = append(byAction[action], policy)
+	}
+
+	for _, policy := range byAction[ActionAudit] {
+		if policyMatches(policy, req, false) {
+			decision.Audited = true
+		}
+	}
+
+	for _, policy := range byAction[ActionCustom] {
+		if policyMatches(policy, req, true) {
+			decision.Custom = policy.Spec.GetProvider().GetName()
+			break
+		}
+	}
+
+	for _, policy := range byAction[ActionDeny] {
+		if policyMatches(policy, req, true) {
+			decision.Action = ActionDeny
+			decision.Policy = policyName(policy)
+			decision.Reason = "matched DENY policy"
+			return decision
+		}
+	}
+
+	allowPolicies := byAction[ActionAllow]
+	if len(allowPolicies) == 0 {
+		decision.Action = ActionAllow
+		decision.Reason = "no ALLOW policy applies to the workload"
+		return decision
+	}
+	for _, policy := range allowPolicies {
+		if policyMatches(policy, req, false) {
+			decision.Action = ActionAllow
+			decision.Policy = policyName(policy)
+			decision.Reason = "matched ALLOW policy"
+			return decision
+		}
+	}
+
+	decision.Action = ActionDeny
+	decision.Reason = fmt.Sprintf("none of %d ALLOW policies matched", len(allowPolicies))
+	return decision
+}
+
+// applicablePolicies returns the policies that select the destination workload, and the ones whose selector
+// depends on labels the request does not carry. Policies in the root namespace apply mesh wide, and policies using
+// targetRefs are attached to gateways or waypoints rather than workloads.
+func (s *Simulator) applicablePolicies(req *Request) ([]*v1beta1.AuthorizationPolicy, []*v1beta1.AuthorizationPolicy) {
+	var policies, unevaluated []*v1beta1.AuthorizationPolicy
+	for _, policy := range s.Policies {
+		if policy.Spec.GetTargetRef() != nil || len(policy.Spec.GetTargetRefs()) > 0 {
+			continue
+		}
+		if policy.Namespace != s.RootNamespace && policy.Namespace != req.DestinationNamespace {
+			continue
+		}
+		switch selectorMatches(policy.Spec.GetSelector().GetMatchLabels(), req.DestinationLabels, req.InferredLabels) {
+		case match:
+			policies = append(policies, policy)
+		case unknown:
+			unevaluated = append(unevaluated, policy)
+		}
+	}
+	return policies, unevaluated
+}
+
+// selectorMatches compares the selector with the labels of the destination. Labels missing from the request are
+// unknown rather than a mismatch, the request only carries the labels found in the metrics. Inferred labels are
+// also unknown when the value could be a prefix of the inferred one, e.g. app: reviews for workload reviews-v1.
+func selectorMatches(selector map[string]string, labels map[string]string, inferred map[string]bool) tristate {
+	result := match
+	for k, v := range selector {
+		value, ok := labels[k]
+		switch {
+		case !ok:
+			result = result.and(unknown)
+		case value == v:
+		case inferred[k] && strings.HasPrefix(value, v+"-"):
+			result = result.and(unknown)
+		default:
+			return noMatch
+		}
+	}
+	return result
+}
+
+func actionName(policy *v1beta1.AuthorizationPolicy) string {
+	return policy.Spec.GetAction().String()
+}
+
+func policyName(policy *v1beta1.AuthorizationPolicy) string {
+	return policy.Namespace + "/" + policy.Name
+}
+
+// policyMatches reports whether any rule of the policy matches. Conditions that the request cannot answer are
+// treated as a match for DENY-like actions and as a mismatch for ALLOW, mirroring how Istio drops HTTP-only
+// fields for TCP traffic.
+func policyMatches(policy *v1beta1.AuthorizationPolicy, req *Request, unknownMatches bool) bool {
+	result := noMatch
+	for _, rule := range policy.Spec.GetRules() {
+		result = result.or(ruleMatches(rule, req))
+	}
+	if result == unknown {
+		return unknownMatches
+	}
+	return result == match
+}
+
+// tristate is the result of matching a field against a request that may not carry that field.
+type tristate int
+
+const (
+	noMatch tristate = iota
+	unknown
+	match
+)
+
+func (t tristate) and(o tristate) tristate {
+	if t < o {
+		return t
+	}
+	return o
+}
+
+func (t tristate) or(o tristate) tristate {
+	if t > o {
+		return t
+	}
+	return o
+}
+
+func ruleMatches(rule *securityv1beta1.Rule, req *Request) tristate {
+	result := match
+	if len(rule.GetFrom()) > 0 {
+		from := noMatch
+		for _, f := range rule.GetFrom() {
+			from = from.or(sourceMatches(f.GetSource(), req))
+		}
+		result = result.and(from)
+	}
+	if len(rule.GetTo()) > 0 {
+		to := noMatch
+		for _, t := range rule.GetTo() {
+			to = to.or(operationMatches(t.GetOperation(), req))
+		}
+		result = result.and(to)
+	}
+	for _, condition := range rule.GetWhen() {
+		result = result.and(conditionMatches(condition, req))
+	}
+	return result
+}
+
+func sourceMatches(source *securityv1beta1.Source, req *Request) tristate {
+	principal := strings.TrimPrefix(req.SourcePrincipal, "spiffe://")
+	result := match
+	result = result.and(fieldMatches(source.GetPrincipals(), source.GetNotPrincipals(), principal, true))
+	result = result.and(fieldMatches(source.GetNamespaces(), source.GetNotNamespaces(), req.SourceNamespace, true))
+	result = result.and(fieldMatches(source.GetServiceAccounts(), source.GetNotServiceAccounts(), serviceAccount(principal), true))
+	if len(source.GetRequestPrincipals()) > 0 || len(source.GetNotRequestPrincipals()) > 0 {
+		result = result.and(unknown)
+	}
+	if len(source.GetIpBlocks()) > 0 || len(source.GetNotIpBlocks()) > 0 ||
+		len(source.GetRemoteIpBlocks()) > 0 || len(source.GetNotRemoteIpBlocks()) > 0 {
+		result = result.and(unknown)
+	}
+	return result
+}
+
+func operationMatches(operation *securityv1beta1.Operation, req *Request) tristate {
+	result := match
+	result = result.and(fieldMatches(operation.GetPorts(), operation.GetNotPorts(), req.Port, req.Port != ""))
+	result = result.and(fieldMatches(operation.GetHosts(), operation.GetNotHosts(), req.Host, req.HTTP && req.Host != ""))
+	result = result.and(fieldMatches(operation.GetMethods(), operation.GetNotMethods(), req.Method, req.HTTP && req.Method != ""))
+	result = result.and(fieldMatches(operation.GetPaths(), operation.GetNotPaths(), req.Path, req.HTTP && req.Path != ""))
+	return result
+}
+
+func conditionMatches(condition *securityv1beta1.Condition, req *Request) tristate {
+	var value string
+	switch condition.GetKey() {
+	case "source.principal":
+		value = strings.TrimPrefix(req.SourcePrincipal, "spiffe://")
+	case "source.namespace":
+		value = req.SourceNamespace
+	case "destination.port":
+		if req.Port == "" {
+			return unknown
+		}
+		value = req.Port
+	default:
+		return unknown
+	}
+	return fieldMatches(condition.GetValues(), condition.GetNotValues(), value, true)
+}
+
+// fieldMatches applies the values/notValues pair of a single policy field. known is false when the request
+// does not carry the field at all.
+func fieldMatches(values []string, notValues []string, value string, known bool) tristate {
+	if len(values) == 0 && len(notValues) == 0 {
+		return match
+	}
+	if !known {
+		return unknown
+	}
+	if len(values) > 0 && !anyValueMatches(values, value) {
+		return noMatch
+	}
+	if len(notValues) > 0 && anyValueMatches(notValues, value) {
+		return noMatch
+	}
+	return match
+}
+
+func anyValueMatches(values []string, value string) bool {
+	for _, v := range values {
+		if valueMatches(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueMatches supports Istio's exact, prefix (`abc*`), suffix (`*abc`) and presence (`*`) matching.
+func valueMatches(pattern string, value string) bool {
+	switch {
+	case pattern == "*":
+		return value != ""
+	case strings.HasPrefix(pattern, "*"):
+		return strings.HasSuffix(value, strings.TrimPrefix(pattern, "*"))
+	case strings.HasSuffix(pattern, "*"):
+		return strings.HasPrefix(value, strings.TrimSuffix(pattern, "*"))
+	default:
+		return pattern == value
+	}
+}
+
+// serviceAccount converts a principal such as cluster.local/ns/default/sa/sleep into default/sleep.
+func serviceAccount(principal string) string {
+	parts := strings.Split(principal, "/")
+	if len(parts) == 5 && parts[1] == "ns" && parts[3] == "sa" {
+		return parts[2] + "/" + parts[4]
+	}
+	return ""
+}
@@ -0,0 +1,259 @@
+package authz
+
+import (
+	securityv1beta1 "istio.io/api/security/v1beta1"
+	typev1beta1 "istio.io/api/type/v1beta1"
+	"istio.io/client-go/pkg/apis/security/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+// policySpec holds the fields of an AuthorizationPolicy spec used by the tests, the spec itself cannot be copied.
+type policySpec struct {
+	Action   securityv1beta1.AuthorizationPolicy_Action
+	Selector *typev1beta1.WorkloadSelector
+	Rules    []*securityv1beta1.Rule
+}
+
+func newPolicy(namespace string, name string, spec *policySpec) *v1beta1.AuthorizationPolicy {
+	policy := &v1beta1.AuthorizationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+	}
+	policy.Spec.Action = spec.Action
+	policy.Spec.Selector = spec.Selector
+	policy.Spec.Rules = spec.Rules
+	return policy
+}
+
+func selector(labels map[string]string) *typev1beta1.WorkloadSelector {
+	return &typev1beta1.WorkloadSelector{MatchLabels: labels}
+}
+
+func fromNamespaces(namespaces ...string) []*securityv1beta1.Rule {
+	return []*securityv1beta1.Rule{{
+		From: []*securityv1beta1.Rule_From{{Source: &securityv1beta1.Source{Namespaces: namespaces}}},
+	}}
+}
+
+func toPorts(ports ...string) []*securityv1beta1.Rule {
+	return []*securityv1beta1.Rule{{
+		To: []*securityv1beta1.Rule_To{{Operation: &securityv1beta1.Operation{Ports: ports}}},
+	}}
+}
+
+func testRequest() *Request {
+	return &Request{
+		SourceWorkload:       "sleep",
+		SourcePrincipal:      "spiffe://cluster.local/ns/ns-1/sa/sleep",
+		SourceNamespace:      "ns-1",
+		DestinationWorkload:  "bold-dream-v1",
+		DestinationNamespace: "ns-2",
+		DestinationLabels:    map[string]string{"app": "bold-dream", "version": "v1"},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name        string
+		policies    []*v1beta1.AuthorizationPolicy
+		request     func(*Request)
+		action      string
+		policy      string
+		unevaluated []string
+	}{
+		{
+			name:   "no policies allows",
+			action: ActionAllow,
+		},
+		{
+			name: "matching allow policy",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns1", &policySpec{Rules: fromNamespaces("ns-1")}),
+			},
+			action: ActionAllow,
+			policy: "ns-2/allow-ns1",
+		},
+		{
+			name: "no matching allow policy denies",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns3", &policySpec{Rules: fromNamespaces("ns-3")}),
+			},
+			action: ActionDeny,
+		},
+		{
+			name: "deny wins over allow",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns1", &policySpec{Rules: fromNamespaces("ns-1")}),
+				newPolicy("istio-system", "deny-ns1", &policySpec{
+					Action: securityv1beta1.AuthorizationPolicy_DENY,
+					Rules:  fromNamespaces("ns-1"),
+				}),
+			},
+			action: ActionDeny,
+			policy: "istio-system/deny-ns1",
+		},
+		{
+			name: "policies in other namespaces are ignored",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-3", "allow-nothing", &policySpec{}),
+			},
+			action: ActionAllow,
+		},
+		{
+			name: "selector on another app is ignored",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-nothing", &policySpec{
+					Selector: selector(map[string]string{"app": "cold-sea"}),
+				}),
+			},
+			action: ActionAllow,
+		},
+		{
+			name: "selector on a label missing from the metrics is unevaluated",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns3", &policySpec{
+					Selector: selector(map[string]string{"app": "bold-dream"}),
+					Rules:    fromNamespaces("ns-3"),
+				}),
+			},
+			request: func(req *Request) {
+				req.DestinationLabels = map[string]string{}
+			},
+			action:      ActionAllow,
+			unevaluated: []string{"ns-2/allow-ns3"},
+		},
+		{
+			name: "selector on the inferred app matches",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns3", &policySpec{
+					Selector: selector(map[string]string{"app": "bold-dream-v1"}),
+					Rules:    fromNamespaces("ns-3"),
+				}),
+			},
+			request: func(req *Request) {
+				req.DestinationLabels = map[string]string{"app": "bold-dream-v1"}
+				req.InferredLabels = map[string]bool{"app": true}
+			},
+			action: ActionDeny,
+		},
+		{
+			name: "selector on a prefix of an inferred label is unevaluated",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns3", &policySpec{
+					Selector: selector(map[string]string{"app": "bold-dream"}),
+					Rules:    fromNamespaces("ns-3"),
+				}),
+			},
+			request: func(req *Request) {
+				req.DestinationLabels = map[string]string{"app": "bold-dream-v1"}
+				req.InferredLabels = map[string]bool{"app": true}
+			},
+			action:      ActionAllow,
+			unevaluated: []string{"ns-2/allow-ns3"},
+		},
+		{
+			name: "selector on an unrelated value of an inferred label is ignored",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-nothing", &policySpec{
+					Selector: selector(map[string]string{"app": "bold-lake-v1"}),
+				}),
+			},
+			request: func(req *Request) {
+				req.DestinationLabels = map[string]string{"app": "bold-dream-v1"}
+				req.InferredLabels = map[string]bool{"app": true}
+			},
+			action: ActionAllow,
+		},
+		{
+			name: "allow on a port missing from the metrics never matches",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-8080", &policySpec{Rules: toPorts("8080")}),
+			},
+			action: ActionDeny,
+		},
+		{
+			name: "deny on a port missing from the metrics always matches",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "deny-8080", &policySpec{
+					Action: securityv1beta1.AuthorizationPolicy_DENY,
+					Rules:  toPorts("8080"),
+				}),
+			},
+			action: ActionDeny,
+			policy: "ns-2/deny-8080",
+		},
+		{
+			name: "allow on an observed port",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-8080", &policySpec{Rules: toPorts("8080")}),
+			},
+			request: func(req *Request) {
+				req.Port = "8080"
+			},
+			action: ActionAllow,
+			policy: "ns-2/allow-8080",
+		},
+		{
+			name: "principal prefix match",
+			policies: []*v1beta1.AuthorizationPolicy{
+				newPolicy("ns-2", "allow-ns1-principals", &policySpec{
+					Rules: []*securityv1beta1.Rule{{
+						From: []*securityv1beta1.Rule_From{{Source: &securityv1beta1.Source{
+							Principals: []string{"cluster.local/ns/ns-1/*"},
+						}}},
+					}},
+				}),
+			},
+			action: ActionAllow,
+			policy: "ns-2/allow-ns1-principals",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			if tt.request != nil {
+				tt.request(req)
+			}
+			decision := NewSimulator("istio-system", tt.policies).Evaluate(req)
+			if decision.Action != tt.action {
+				t.Errorf("action = %s, want %s (%s)", decision.Action, tt.action, decision.Reason)
+			}
+			if decision.Policy != tt.policy {
+				t.Errorf("policy = %q, want %q", decision.Policy, tt.policy)
+			}
+			if len(decision.Unevaluated) != len(tt.unevaluated) {
+				t.Fatalf("unevaluated = %v, want %v", decision.Unevaluated, tt.unevaluated)
+			}
+			for i := range tt.unevaluated {
+				if decision.Unevaluated[i] != tt.unevaluated[i] {
+					t.Errorf("unevaluated = %v, want %v", decision.Unevaluated, tt.unevaluated)
+				}
+			}
+			if decision.Uncertain() != (len(tt.unevaluated) > 0) {
+				t.Errorf("uncertain = %v, want %v", decision.Uncertain(), len(tt.unevaluated) > 0)
+			}
+		})
+	}
+}
+
+func TestValueMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"GET", "GET", true},
+		{"GET", "POST", false},
+		{"/api/*", "/api/users", true},
+		{"/api/*", "/health", false},
+		{"*.local", "svc.cluster.local", true},
+		{"*", "anything", true},
+		{"*", "", false},
+	}
+	for _, tt := range tests {
+		if got := valueMatches(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("valueMatches(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package diff
+
+import (
+	networkingv1 "istio.io/client-go/pkg/apis/networking/v1"
+	"istio.io/client-go/pkg/apis/security/v1beta1"
+	networkingk8sv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sort"
+	"strings"
+)
+
+// WorkloadDiff is the semantic difference between the generated and deployed policy of a single workload.
+type WorkloadDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Added holds the principals, hosts or peers only present in the generated policy.
+	Added []string
+	// Removed holds the principals, hosts or peers only present in the deployed policy.
+	Removed []string
+	// New is set when nothing is deployed for the workload yet.
+	New bool
+	// Stale is set when a deployed policy has no generated counterpart.
+	Stale bool
+}
+
+func (w *WorkloadDiff) Changed() bool {
+	return w.New || w.Stale || len(w.Added) > 0 || len(w.Removed) > 0
+}
+
+type policyKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// Compare diffs generated policies against the deployed ones, matching them by kind, namespace and name.
+// AuthorizationPolicies are compared by principals, Sidecars by egress hosts and NetworkPolicies by ingress peers.
+func Compare(generated []runtime.Object, existing []runtime.Object) []*WorkloadDiff {
+	generatedValues := valuesByPolicy(generated)
+	existingValues := valuesByPolicy(existing)
+
+	var diffs []*WorkloadDiff
+	for key, values := range generatedValues {
+		diff := &WorkloadDiff{Kind: key.kind, Namespace: key.namespace, Name: key.name}
+		deployed, ok := existingValues[key]
+		if !ok {
+			diff.New = true
+		}
+		diff.Added, diff.Removed = Sets(values, deployed)
+		diffs = append(diffs, diff)
+	}
+	for key, values := range existingValues {
+		if _, ok := generatedValues[key]; ok {
+			continue
+		}
+		diff := &WorkloadDiff{Kind: key.kind, Namespace: key.namespace, Name: key.name, Stale: true}
+		diff.Added, diff.Removed = Sets(nil, values)
+		diffs = append(diffs, diff)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Namespace != diffs[j].Namespace {
+			return diffs[i].Namespace < diffs[j].Namespace
+		}
+		if diffs[i].Name != diffs[j].Name {
+			return diffs[i].Name < diffs[j].Name
+		}
+		return diffs[i].Kind < diffs[j].Kind
+	})
+	return diffs
+}
+
+// Sets returns the sorted values only found in a and the sorted values only found in b.
+func Sets(a []string, b []string) ([]string, []string) {
+	inA := map[string]bool{}
+	for _, v := range a {
+		inA[v] = true
+	}
+	inB := map[string]bool{}
+	for _, v := range b {
+		inB[v] = true
+	}
+
+	var onlyA, onlyB []string
+	for v := range inA {
+		if !inB[v] {
+			onlyA = append(onlyA, v)
+		}
+	}
+	for v := range inB {
+		if !inA[v] {
+			onlyB = append(onlyB, v)
+		}
+	}
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	return onlyA, onlyB
+}
+
+func valuesByPolicy(objects []runtime.Object) map[policyKey][]string {
+	values := map[policyKey][]string{}
+	for _, obj := range objects {
+		switch policy := obj.(type) {
+		case *v1beta1.AuthorizationPolicy:
+			key := policyKey{kind: "AuthorizationPolicy", namespace: policy.Namespace, name: policy.Name}
+			values[key] = append(values[key], principals(policy)...)
+		case *networkingv1.Sidecar:
+			key := policyKey{kind: "Sidecar", namespace: policy.Namespace, name: policy.Name}
+			values[key] = append(values[key], egressHosts(policy)...)
+		case *networkingk8sv1.NetworkPolicy:
+			key := policyKey{kind: "NetworkPolicy", namespace: policy.Namespace, name: policy.Name}
+			values[key] = append(values[key], ingressPeers(policy)...)
+		}
+	}
+	return values
+}
+
+func principals(policy *v1beta1.AuthorizationPolicy) []string {
+	var principals []string
+	for _, rule := range policy.Spec.GetRules() {
+		for _, from := range rule.GetFrom() {
+			principals = append(principals, from.GetSource().GetPrincipals()...)
+		}
+	}
+	return principals
+}
+
+func egressHosts(sidecar *networkingv1.Sidecar) []string {
+	var hosts []string
+	for _, egress := range sidecar.Spec.GetEgress() {
+		hosts = append(hosts, egress.GetHosts()...)
+	}
+	return hosts
+}
+
+// ingressPeers describes each allowed peer as <namespace selector>/<pod selector>, suffixed with the allowed ports.
+func ingressPeers(policy *networkingk8sv1.NetworkPolicy) []string {
+	var peers []string
+	for _, ingress := range policy.Spec.Ingress {
+		var ports []string
+		for _, port := range ingress.Ports {
+			if port.Port != nil {
+				ports = append(ports, port.Port.String())
+			}
+		}
+		for _, from := range ingress.From {
+			peer := metav1.FormatLabelSelector(from.NamespaceSelector) + "/" + metav1.FormatLabelSelector(from.PodSelector)
+			if len(ports) > 0 {
+				peer += ":" + strings.Join(ports, ",")
+			}
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
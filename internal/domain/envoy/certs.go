@@ -0,0 +1,40 @@
+package envoy
+
+import (
+	"strings"
+	"time"
+)
+
+// Certs is the response of the Envoy admin certs endpoint.
+type Certs struct {
+	Certificates []CertificateChain `json:"certificates"`
+}
+
+type CertificateChain struct {
+	CACert    []CertificateDetails `json:"ca_cert"`
+	CertChain []CertificateDetails `json:"cert_chain"`
+}
+
+type CertificateDetails struct {
+	Path            string           `json:"path"`
+	SerialNumber    string           `json:"serial_number"`
+	SubjectAltNames []SubjectAltName `json:"subject_alt_names"`
+	ValidFrom       time.Time        `json:"valid_from"`
+	ExpirationTime  time.Time        `json:"expiration_time"`
+}
+
+type SubjectAltName struct {
+	URI       string `json:"uri,omitempty"`
+	DNS       string `json:"dns,omitempty"`
+	IPAddress string `json:"ip_address,omitempty"`
+}
+
+// SPIFFEID returns the first spiffe:// URI SAN of the certificate.
+func (c CertificateDetails) SPIFFEID() string {
+	for _, san := range c.SubjectAltNames {
+		if strings.HasPrefix(san.URI, "spiffe://") {
+			return san.URI
+		}
+	}
+	return ""
+}
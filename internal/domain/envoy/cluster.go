@@ -1,5 +1,7 @@
 package envoy
 
+import "strconv"
+
 type SocketAddress struct {
 	Address   string `json:"address"`
 	PortValue int    `json:"port_value"`
@@ -19,7 +21,50 @@ type Stat struct {
 }
 
 type HealthStatus struct {
-	EdsHealthStatus string `json:"eds_health_status"`
+	EdsHealthStatus            string `json:"eds_health_status"`
+	FailedActiveHealthCheck    bool   `json:"failed_active_health_check,omitempty"`
+	FailedOutlierCheck         bool   `json:"failed_outlier_check,omitempty"`
+	FailedActiveDegradedCheck  bool   `json:"failed_active_degraded_check,omitempty"`
+	PendingDynamicRemoval      bool   `json:"pending_dynamic_removal,omitempty"`
+	PendingActiveHc            bool   `json:"pending_active_hc,omitempty"`
+	ExcludedViaImmediateHcFail bool   `json:"excluded_via_immediate_hc_fail,omitempty"`
+	ActiveHcTimeout            bool   `json:"active_hc_timeout,omitempty"`
+}
+
+// FailedChecks returns the health check flags that are set on the host.
+func (h HealthStatus) FailedChecks() []string {
+	var checks []string
+	if h.FailedActiveHealthCheck {
+		checks = append(checks, "FAILED_ACTIVE_HC")
+	}
+	if h.FailedOutlierCheck {
+		checks = append(checks, "FAILED_OUTLIER_CHECK")
+	}
+	if h.FailedActiveDegradedCheck {
+		checks = append(checks, "FAILED_ACTIVE_DEGRADED_CHECK")
+	}
+	if h.PendingDynamicRemoval {
+		checks = append(checks, "PENDING_DYNAMIC_REMOVAL")
+	}
+	if h.PendingActiveHc {
+		checks = append(checks, "PENDING_ACTIVE_HC")
+	}
+	if h.ExcludedViaImmediateHcFail {
+		checks = append(checks, "EXCLUDED_VIA_IMMEDIATE_HC_FAIL")
+	}
+	if h.ActiveHcTimeout {
+		checks = append(checks, "ACTIVE_HC_TIMEOUT")
+	}
+	return checks
+}
+
+// Healthy reports whether Envoy would send traffic to the host. Envoy treats an UNKNOWN EDS status as healthy.
+func (h HealthStatus) Healthy() bool {
+	switch h.EdsHealthStatus {
+	case "", "HEALTHY", "UNKNOWN":
+		return len(h.FailedChecks()) == 0
+	}
+	return false
 }
 
 type HostStatus struct {
@@ -29,6 +74,39 @@ type HostStatus struct {
 	Weight       int          `json:"weight"`
 	Hostname     string       `json:"hostname,omitempty"`
 	Locality     Locality     `json:"locality"`
+	Priority     int          `json:"priority,omitempty"`
+	// SuccessRate is only reported when success rate outlier detection is enabled for the cluster.
+	SuccessRate            *Percent `json:"success_rate,omitempty"`
+	LocalOriginSuccessRate *Percent `json:"local_origin_success_rate,omitempty"`
+}
+
+type Percent struct {
+	Value float64 `json:"value"`
+}
+
+// StatValue returns the value of a counter or gauge, Envoy omits the value of stats that are zero.
+func (h HostStatus) StatValue(name string) int64 {
+	for _, stat := range h.Stats {
+		if stat.Name == name {
+			value, _ := strconv.ParseInt(stat.Value, 10, 64)
+			return value
+		}
+	}
+	return 0
+}
+
+// IsIdle reports whether every stat of the host is zero. Hosts without any stats, such as the ones read from
+// ztunnel, are never idle.
+func (h HostStatus) IsIdle() bool {
+	if len(h.Stats) == 0 {
+		return false
+	}
+	for _, stat := range h.Stats {
+		if value, err := strconv.ParseInt(stat.Value, 10, 64); err == nil && value != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 type Locality struct {
@@ -37,6 +115,21 @@ type Locality struct {
 	SubZone string `json:"sub_zone"`
 }
 
+// String joins the region, zone and sub zone, e.g. us-east/us-east-c.
+func (l Locality) String() string {
+	var locality string
+	if l.Region != "" {
+		locality = l.Region
+	}
+	if l.Zone != "" {
+		locality += "/" + l.Zone
+	}
+	if l.SubZone != "" {
+		locality += "/" + l.SubZone
+	}
+	return locality
+}
+
 type Threshold struct {
 	MaxConnections     int    `json:"max_connections"`
 	MaxPendingRequests int    `json:"max_pending_requests"`
@@ -49,6 +142,16 @@ type CircuitBreakers struct {
 	Thresholds []Threshold `json:"thresholds"`
 }
 
+// DefaultThreshold returns the thresholds of the DEFAULT routing priority.
+func (c CircuitBreakers) DefaultThreshold() (Threshold, bool) {
+	for _, threshold := range c.Thresholds {
+		if threshold.Priority == "" || threshold.Priority == "DEFAULT" {
+			return threshold, true
+		}
+	}
+	return Threshold{}, false
+}
+
 type ClusterStatus struct {
 	Name              string          `json:"name"`
 	AddedViaAPI       bool            `json:"added_via_api"`
@@ -56,6 +159,20 @@ type ClusterStatus struct {
 	CircuitBreakers   CircuitBreakers `json:"circuit_breakers"`
 	ObservabilityName string          `json:"observability_name"`
 	EdsServiceName    string          `json:"eds_service_name,omitempty"`
+	// The ejection thresholds are only reported when success rate outlier detection is enabled for the cluster.
+	SuccessRateEjectionThreshold            *Percent `json:"success_rate_ejection_threshold,omitempty"`
+	LocalOriginSuccessRateEjectionThreshold *Percent `json:"local_origin_success_rate_ejection_threshold,omitempty"`
+}
+
+// EjectedHosts returns the number of hosts that failed the outlier check.
+func (c ClusterStatus) EjectedHosts() int {
+	var ejected int
+	for _, hs := range c.HostStatuses {
+		if hs.HealthStatus.FailedOutlierCheck {
+			ejected++
+		}
+	}
+	return ejected
 }
 
 type Clusters struct {
@@ -0,0 +1,29 @@
+package envoy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ClusterName is the parsed form of an Istio cluster name such as outbound|9080|v1|reviews.default.svc.cluster.local.
+// Clusters that do not follow the convention, like BlackHoleCluster or xds-grpc, only have FQDN set to their name.
+type ClusterName struct {
+	Direction string
+	Port      int
+	Subset    string
+	FQDN      string
+}
+
+func ParseClusterName(name string) ClusterName {
+	parts := strings.Split(name, "|")
+	if len(parts) != 4 {
+		return ClusterName{FQDN: name}
+	}
+	port, _ := strconv.Atoi(parts[1])
+	return ClusterName{
+		Direction: parts[0],
+		Port:      port,
+		Subset:    parts[2],
+		FQDN:      parts[3],
+	}
+}
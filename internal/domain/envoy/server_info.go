@@ -0,0 +1,40 @@
+package envoy
+
+import (
+	"strings"
+)
+
+// ServerInfo is the part of the Envoy admin server_info response describing the proxy build and its xDS node.
+type ServerInfo struct {
+	Version            string `json:"version"`
+	State              string `json:"state"`
+	UptimeCurrentEpoch string `json:"uptime_current_epoch"`
+	Node               Node   `json:"node"`
+}
+
+// Node is the xDS node the proxy identifies itself with. Istio adds its own metadata such as ISTIO_VERSION.
+type Node struct {
+	ID       string                 `json:"id"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// EnvoyVersion returns the release from a version such as <sha>/1.29.1-dev/Clean/RELEASE/BoringSSL.
+func (s *ServerInfo) EnvoyVersion() string {
+	parts := strings.Split(s.Version, "/")
+	if len(parts) < 2 {
+		return s.Version
+	}
+	return parts[1]
+}
+
+// IstioVersion returns the ISTIO_VERSION node metadata set by the istio-agent.
+func (s *ServerInfo) IstioVersion() string {
+	version, _ := s.Node.Metadata["ISTIO_VERSION"].(string)
+	return version
+}
+
+// ProxyType returns the type of the node id, e.g. sidecar or router for sidecar~10.42.0.29~reviews.default~...
+func (s *ServerInfo) ProxyType() string {
+	proxyType, _, _ := strings.Cut(s.Node.ID, "~")
+	return proxyType
+}
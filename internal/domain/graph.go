@@ -0,0 +1,174 @@
+package domain
+
+import (
+	"sort"
+)
+
+// Workload is a node of the dependency graph.
+type Workload struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+func (w Workload) String() string {
+	if w.Namespace == "" {
+		return w.Name
+	}
+	return w.Namespace + "/" + w.Name
+}
+
+// Edge is the traffic from one workload to another through a service.
+type Edge struct {
+	Source      Workload `json:"source"`
+	Destination Workload `json:"destination"`
+	Service     string   `json:"service,omitempty"`
+	// Value is the sum of the metric the graph was built from, e.g. requests or bytes.
+	Value float64 `json:"value"`
+}
+
+// Graph is the workload dependency graph with the edges indexed by caller and callee.
+type Graph struct {
+	Edges   []*Edge
+	callees map[Workload][]*Edge
+	callers map[Workload][]*Edge
+}
+
+// NewGraph merges the edges with the same source, destination and service.
+func NewGraph(edges []*Edge) *Graph {
+	g := &Graph{callees: map[Workload][]*Edge{}, callers: map[Workload][]*Edge{}}
+	merged := map[Edge]*Edge{}
+	for _, e := range edges {
+		key := Edge{Source: e.Source, Destination: e.Destination, Service: e.Service}
+		if existing, ok := merged[key]; ok {
+			existing.Value += e.Value
+			continue
+		}
+		edge := *e
+		merged[key] = &edge
+		g.Edges = append(g.Edges, &edge)
+	}
+	sort.SliceStable(g.Edges, func(i, j int) bool {
+		return g.Edges[i].Service < g.Edges[j].Service
+	})
+	sortEdges(g.Edges)
+	// index the sorted edges so walks do not depend on the order the samples were read in
+	for _, edge := range g.Edges {
+		g.callees[edge.Source] = append(g.callees[edge.Source], edge)
+		g.callers[edge.Destination] = append(g.callers[edge.Destination], edge)
+	}
+	return g
+}
+
+// Workloads returns every source and destination of the graph, sorted.
+func (g *Graph) Workloads() []Workload {
+	seen := map[Workload]bool{}
+	var workloads []Workload
+	for _, e := range g.Edges {
+		for _, w := range []Workload{e.Source, e.Destination} {
+			if !seen[w] {
+				seen[w] = true
+				workloads = append(workloads, w)
+			}
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		return workloads[i].String() < workloads[j].String()
+	})
+	return workloads
+}
+
+// Callers returns the edges into the workload.
+func (g *Graph) Callers(w Workload) []*Edge {
+	return g.callers[w]
+}
+
+// Callees returns the edges out of the workload.
+func (g *Graph) Callees(w Workload) []*Edge {
+	return g.callees[w]
+}
+
+// Upstream walks the reverse graph from the targets and returns every transitive caller with the number of hops to
+// the closest target.
+func (g *Graph) Upstream(targets []Workload) map[Workload]int {
+	hops := map[Workload]int{}
+	for _, t := range targets {
+		hops[t] = 0
+	}
+	queue := append([]Workload{}, targets...)
+	for len(queue) > 0 {
+		w := queue[0]
+		queue = queue[1:]
+		for _, e := range g.callers[w] {
+			if _, seen := hops[e.Source]; !seen {
+				hops[e.Source] = hops[w] + 1
+				queue = append(queue, e.Source)
+			}
+		}
+	}
+	for _, t := range targets {
+		delete(hops, t)
+	}
+	return hops
+}
+
+// Exposure estimates the share of a workload's outbound traffic that depends on the targets, assuming a workload
+// spreads the calls it makes to its callees over the requests it handles. A call to a target counts fully, a call to
+// another workload counts with that workload's exposure. A call back to a workload already on the path of the
+// estimate counts as not exposed, so every workload of a cycle is estimated from its own position in it.
+func (g *Graph) Exposure(targets []Workload) map[Workload]float64 {
+	isTarget := map[Workload]bool{}
+	for _, t := range targets {
+		isTarget[t] = true
+	}
+	// acyclic holds the estimates that did not cut a cycle, they are the same from every starting point
+	acyclic := map[Workload]float64{}
+	exposure := map[Workload]float64{}
+	for _, w := range g.Workloads() {
+		if !isTarget[w] {
+			exposure[w] = g.exposureFrom(w, isTarget, acyclic)
+		}
+	}
+	return exposure
+}
+
+func (g *Graph) exposureFrom(start Workload, isTarget map[Workload]bool, acyclic map[Workload]float64) float64 {
+	cyclic := map[Workload]float64{}
+	visiting := map[Workload]bool{}
+	var visit func(w Workload) (float64, bool)
+	visit = func(w Workload) (float64, bool) {
+		if isTarget[w] {
+			return 1, false
+		}
+		if value, ok := acyclic[w]; ok {
+			return value, false
+		}
+		if value, ok := cyclic[w]; ok {
+			return value, true
+		}
+		if visiting[w] {
+			return 0, true
+		}
+		visiting[w] = true
+		var total, exposed float64
+		var cut bool
+		for _, e := range g.callees[w] {
+			value, c := visit(e.Destination)
+			total += e.Value
+			exposed += e.Value * value
+			cut = cut || c
+		}
+		delete(visiting, w)
+		value := 0.0
+		if total > 0 {
+			value = exposed / total
+		}
+		if cut {
+			cyclic[w] = value
+		} else {
+			acyclic[w] = value
+		}
+		return value, cut
+	}
+	value, _ := visit(start)
+	return value
+}
@@ -0,0 +1,73 @@
+package domain
+
+import (
+	"sort"
+)
+
+// GraphDiff holds the dependencies that appeared or disappeared between two graphs. Edges are compared by their
+// source and destination workloads, the services they were reached through are ignored.
+type GraphDiff struct {
+	AddedEdges       []*Edge    `json:"added_edges"`
+	RemovedEdges     []*Edge    `json:"removed_edges"`
+	AddedWorkloads   []Workload `json:"added_workloads"`
+	RemovedWorkloads []Workload `json:"removed_workloads"`
+}
+
+// CompareGraphs returns what changed from the first graph to the second one.
+func CompareGraphs(from *Graph, to *Graph) *GraphDiff {
+	fromEdges, toEdges := workloadEdges(from), workloadEdges(to)
+	diff := &GraphDiff{AddedEdges: []*Edge{}, RemovedEdges: []*Edge{}, AddedWorkloads: []Workload{}, RemovedWorkloads: []Workload{}}
+	for key, edge := range toEdges {
+		if _, ok := fromEdges[key]; !ok {
+			diff.AddedEdges = append(diff.AddedEdges, edge)
+		}
+	}
+	for key, edge := range fromEdges {
+		if _, ok := toEdges[key]; !ok {
+			diff.RemovedEdges = append(diff.RemovedEdges, edge)
+		}
+	}
+	sortEdges(diff.AddedEdges)
+	sortEdges(diff.RemovedEdges)
+
+	fromWorkloads, toWorkloads := map[Workload]bool{}, map[Workload]bool{}
+	for _, w := range from.Workloads() {
+		fromWorkloads[w] = true
+	}
+	for _, w := range to.Workloads() {
+		toWorkloads[w] = true
+		if !fromWorkloads[w] {
+			diff.AddedWorkloads = append(diff.AddedWorkloads, w)
+		}
+	}
+	for _, w := range from.Workloads() {
+		if !toWorkloads[w] {
+			diff.RemovedWorkloads = append(diff.RemovedWorkloads, w)
+		}
+	}
+	return diff
+}
+
+// workloadEdges merges the edges between the same workloads over all services.
+func workloadEdges(g *Graph) map[[2]Workload]*Edge {
+	edges := map[[2]Workload]*Edge{}
+	for _, e := range g.Edges {
+		key := [2]Workload{e.Source, e.Destination}
+		if existing, ok := edges[key]; ok {
+			existing.Value += e.Value
+			continue
+		}
+		edges[key] = &Edge{Source: e.Source, Destination: e.Destination, Value: e.Value}
+	}
+	return edges
+}
+
+func sortEdges(edges []*Edge) {
+	sort.SliceStable(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.Source != b.Source {
+			return a.Source.String() < b.Source.String()
+		}
+		return a.Destination.String() < b.Destination.String()
+	})
+}
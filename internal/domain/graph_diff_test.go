@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"testing"
+)
+
+func edge(source string, destination string, service string, value float64) *Edge {
+	return &Edge{
+		Source:      Workload{Name: source, Namespace: "ns-1"},
+		Destination: Workload{Name: destination, Namespace: "ns-1"},
+		Service:     service,
+		Value:       value,
+	}
+}
+
+func edgeNames(edges []*Edge) []string {
+	var names []string
+	for _, e := range edges {
+		names = append(names, e.Source.Name+"->"+e.Destination.Name)
+	}
+	return names
+}
+
+func workloadNames(workloads []Workload) []string {
+	var names []string
+	for _, w := range workloads {
+		names = append(names, w.Name)
+	}
+	return names
+}
+
+func assertNames(t *testing.T, what string, got []string, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s = %v, want %v", what, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s = %v, want %v", what, got, want)
+			return
+		}
+	}
+}
+
+func TestCompareGraphs(t *testing.T) {
+	from := NewGraph([]*Edge{
+		edge("a", "b", "b-svc", 10),
+		edge("b", "c", "c-svc", 5),
+		edge("d", "c", "c-svc", 1),
+	})
+	to := NewGraph([]*Edge{
+		edge("a", "b", "b-svc", 7),
+		edge("a", "b", "b-other", 3),
+		edge("b", "e", "e-svc", 2),
+		edge("a", "c", "c-svc", 4),
+	})
+
+	diff := CompareGraphs(from, to)
+	assertNames(t, "added edges", edgeNames(diff.AddedEdges), []string{"a->c", "b->e"})
+	assertNames(t, "removed edges", edgeNames(diff.RemovedEdges), []string{"b->c", "d->c"})
+	assertNames(t, "added workloads", workloadNames(diff.AddedWorkloads), []string{"e"})
+	assertNames(t, "removed workloads", workloadNames(diff.RemovedWorkloads), []string{"d"})
+}
+
+func TestCompareGraphsUnchanged(t *testing.T) {
+	g := NewGraph([]*Edge{edge("a", "b", "b-svc", 10), edge("b", "a", "a-svc", 1)})
+	diff := CompareGraphs(g, g)
+	if len(diff.AddedEdges) != 0 || len(diff.RemovedEdges) != 0 || len(diff.AddedWorkloads) != 0 || len(diff.RemovedWorkloads) != 0 {
+		t.Errorf("CompareGraphs of the same graph = %+v, want no changes", diff)
+	}
+}
+
+func TestWorkloadEdgesMergeServices(t *testing.T) {
+	g := NewGraph([]*Edge{edge("a", "b", "b-svc", 7), edge("a", "b", "b-other", 3)})
+	edges := workloadEdges(g)
+	if len(edges) != 1 {
+		t.Fatalf("workloadEdges = %d edge(s), want 1", len(edges))
+	}
+	for _, e := range edges {
+		if e.Value != 10 {
+			t.Errorf("merged value = %v, want 10", e.Value)
+		}
+	}
+}
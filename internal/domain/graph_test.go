@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// loadExampleGraph builds the graph of a prometheus formatted example file.
+func loadExampleGraph(t *testing.T, file string) *Graph {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var samples []struct {
+		Metric map[string]string `json:"metric"`
+		Value  [2]interface{}    `json:"value"`
+	}
+	if err := json.Unmarshal(data, &samples); err != nil {
+		t.Fatal(err)
+	}
+	var edges []*Edge
+	for _, sample := range samples {
+		value, err := strconv.ParseFloat(sample.Value[1].(string), 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges = append(edges, &Edge{
+			Source:      Workload{Name: sample.Metric["source_workload"], Namespace: sample.Metric["source_workload_namespace"]},
+			Destination: Workload{Name: sample.Metric["destination_workload"], Namespace: sample.Metric["destination_workload_namespace"]},
+			Service:     sample.Metric["destination_service"],
+			Value:       value,
+		})
+	}
+	return NewGraph(edges)
+}
+
+func ns1(name string) Workload {
+	return Workload{Name: name, Namespace: "ns-1"}
+}
+
+func TestUpstreamCircular(t *testing.T) {
+	g := loadExampleGraph(t, "../../examples/circular.json")
+	hops := g.Upstream([]Workload{ns1("broken-shadow-v1")})
+	want := map[Workload]int{
+		ns1("crimson-sky-v1"):      1,
+		ns1("crimson-sky-v2"):      1,
+		ns1("crimson-sky-v3"):      1,
+		ns1("bold-dream-v1"):       2,
+		ns1("super-bold-dream-v1"): 3,
+		ns1("broken-smoke-v1"):     3,
+	}
+	if len(hops) != len(want) {
+		t.Errorf("Upstream = %v, want %v", hops, want)
+	}
+	for w, h := range want {
+		if hops[w] != h {
+			t.Errorf("Upstream[%s] = %d, want %d", w, hops[w], h)
+		}
+	}
+}
+
+func TestUpstreamInsideCycle(t *testing.T) {
+	g := loadExampleGraph(t, "../../examples/circular.json")
+	hops := g.Upstream([]Workload{ns1("bold-dream-v1")})
+	want := map[Workload]int{
+		ns1("super-bold-dream-v1"): 1,
+		ns1("broken-smoke-v1"):     1,
+	}
+	if len(hops) != len(want) {
+		t.Errorf("Upstream = %v, want %v", hops, want)
+	}
+	for w, h := range want {
+		if hops[w] != h {
+			t.Errorf("Upstream[%s] = %d, want %d", w, hops[w], h)
+		}
+	}
+}
+
+func TestExposureCircular(t *testing.T) {
+	g := loadExampleGraph(t, "../../examples/circular.json")
+	exposure := g.Exposure([]Workload{ns1("broken-shadow-v1")})
+	want := map[Workload]float64{
+		ns1("crimson-sky-v1"): 1,
+		ns1("crimson-sky-v2"): 1,
+		ns1("crimson-sky-v3"): 1,
+		// half of the traffic goes to crimson-sky-v2, the other half back into the cycle
+		ns1("bold-dream-v1"):       0.5,
+		ns1("super-bold-dream-v1"): 0.5,
+		ns1("broken-smoke-v1"):     0.5,
+	}
+	for w, v := range want {
+		if math.Abs(exposure[w]-v) > 1e-9 {
+			t.Errorf("Exposure[%s] = %v, want %v", w, exposure[w], v)
+		}
+	}
+	if _, ok := exposure[ns1("broken-shadow-v1")]; ok {
+		t.Errorf("Exposure contains the target")
+	}
+}
+
+func TestExposureSplitsTraffic(t *testing.T) {
+	g := NewGraph([]*Edge{
+		edge("gateway", "frontend", "frontend", 100),
+		edge("frontend", "reviews", "reviews", 75),
+		edge("frontend", "details", "details", 25),
+		edge("reviews", "ratings", "ratings", 10),
+	})
+	exposure := g.Exposure([]Workload{ns1("ratings")})
+	want := map[Workload]float64{
+		ns1("reviews"):  1,
+		ns1("frontend"): 0.75,
+		ns1("gateway"):  0.75,
+		ns1("details"):  0,
+	}
+	for w, v := range want {
+		if math.Abs(exposure[w]-v) > 1e-9 {
+			t.Errorf("Exposure[%s] = %v, want %v", w, exposure[w], v)
+		}
+	}
+}
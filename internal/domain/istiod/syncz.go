@@ -0,0 +1,51 @@
+package istiod
+
+// SyncStatus is an entry of the istiod debug/syncz response, the xDS resources istiod sent to one proxy and the
+// ones the proxy acknowledged.
+type SyncStatus struct {
+	ClusterID     string `json:"cluster_id,omitempty"`
+	ProxyID       string `json:"proxy,omitempty"`
+	ProxyType     string `json:"proxy_type,omitempty"`
+	ProxyVersion  string `json:"proxy_version,omitempty"`
+	IstioVersion  string `json:"istio_version,omitempty"`
+	ClusterSent   string `json:"cluster_sent,omitempty"`
+	ClusterAcked  string `json:"cluster_acked,omitempty"`
+	ListenerSent  string `json:"listener_sent,omitempty"`
+	ListenerAcked string `json:"listener_acked,omitempty"`
+	RouteSent     string `json:"route_sent,omitempty"`
+	RouteAcked    string `json:"route_acked,omitempty"`
+	EndpointSent  string `json:"endpoint_sent,omitempty"`
+	EndpointAcked string `json:"endpoint_acked,omitempty"`
+}
+
+const (
+	Synced  = "SYNCED"
+	NotSent = "NOT SENT"
+	Stale   = "STALE"
+)
+
+// XDSStatus compares the nonce istiod sent with the one the proxy acknowledged.
+func XDSStatus(sent string, acked string) string {
+	switch {
+	case sent == "":
+		return NotSent
+	case sent == acked:
+		return Synced
+	}
+	return Stale
+}
+
+// IsStale is true when any resource type sent to the proxy has not been acknowledged yet.
+func (s *SyncStatus) IsStale() bool {
+	for _, status := range []string{
+		XDSStatus(s.ClusterSent, s.ClusterAcked),
+		XDSStatus(s.ListenerSent, s.ListenerAcked),
+		XDSStatus(s.RouteSent, s.RouteAcked),
+		XDSStatus(s.EndpointSent, s.EndpointAcked),
+	} {
+		if status == Stale {
+			return true
+		}
+	}
+	return false
+}
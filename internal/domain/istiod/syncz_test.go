@@ -0,0 +1,60 @@
+package istiod
+
+import (
+	"testing"
+)
+
+func TestXDSStatus(t *testing.T) {
+	tests := []struct {
+		sent  string
+		acked string
+		want  string
+	}{
+		{sent: "", acked: "", want: NotSent},
+		{sent: "", acked: "abc", want: NotSent},
+		{sent: "abc", acked: "abc", want: Synced},
+		{sent: "abc", acked: "", want: Stale},
+		{sent: "def", acked: "abc", want: Stale},
+	}
+	for _, tt := range tests {
+		if got := XDSStatus(tt.sent, tt.acked); got != tt.want {
+			t.Errorf("XDSStatus(%q, %q) = %s, want %s", tt.sent, tt.acked, got, tt.want)
+		}
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	tests := []struct {
+		name   string
+		status *SyncStatus
+		want   bool
+	}{
+		{
+			name:   "nothing sent",
+			status: &SyncStatus{},
+		},
+		{
+			name: "all acknowledged",
+			status: &SyncStatus{
+				ClusterSent: "a", ClusterAcked: "a",
+				ListenerSent: "b", ListenerAcked: "b",
+				RouteSent: "c", RouteAcked: "c",
+				EndpointSent: "d", EndpointAcked: "d",
+			},
+		},
+		{
+			name:   "routes not sent",
+			status: &SyncStatus{ClusterSent: "a", ClusterAcked: "a", RouteAcked: "c"},
+		},
+		{
+			name:   "endpoints not acknowledged",
+			status: &SyncStatus{ClusterSent: "a", ClusterAcked: "a", EndpointSent: "e", EndpointAcked: "d"},
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.status.IsStale(); got != tt.want {
+			t.Errorf("%s: IsStale() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
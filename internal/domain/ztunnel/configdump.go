@@ -0,0 +1,171 @@
+package ztunnel
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ConfigDump is the part of the ztunnel admin config_dump used to inspect ambient workloads. Older ztunnel
+// releases key workloads, services and endpoints by address, newer ones return lists, both are accepted.
+type ConfigDump struct {
+	Workloads []*Workload
+	Services  []*Service
+}
+
+func (d *ConfigDump) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Workloads json.RawMessage `json:"workloads"`
+		Services  json.RawMessage `json:"services"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := unmarshalListOrMap(raw.Workloads, &d.Workloads); err != nil {
+		return err
+	}
+	return unmarshalListOrMap(raw.Services, &d.Services)
+}
+
+type Workload struct {
+	UID            string          `json:"uid"`
+	WorkloadIPs    []string        `json:"workloadIps"`
+	Waypoint       *GatewayAddress `json:"waypoint,omitempty"`
+	Protocol       string          `json:"protocol"`
+	Name           string          `json:"name"`
+	Namespace      string          `json:"namespace"`
+	ServiceAccount string          `json:"serviceAccount"`
+	WorkloadName   string          `json:"workloadName"`
+	Node           string          `json:"node"`
+	Status         string          `json:"status"`
+	Locality       Locality        `json:"locality,omitempty"`
+}
+
+func (w *Workload) Healthy() bool {
+	return w.Status == "Healthy"
+}
+
+type Service struct {
+	Name      string          `json:"name"`
+	Namespace string          `json:"namespace"`
+	Hostname  string          `json:"hostname"`
+	Addresses []string        `json:"vips"`
+	Ports     map[string]int  `json:"ports"`
+	Waypoint  *GatewayAddress `json:"waypoint,omitempty"`
+	Endpoints []*Endpoint     `json:"-"`
+}
+
+func (s *Service) UnmarshalJSON(data []byte) error {
+	type plain Service
+	var raw struct {
+		*plain
+		Endpoints json.RawMessage `json:"endpoints"`
+	}
+	raw.plain = (*plain)(s)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	return unmarshalListOrMap(raw.Endpoints, &s.Endpoints)
+}
+
+type Endpoint struct {
+	WorkloadUID string `json:"workloadUid"`
+	Service     string `json:"service"`
+	Address     string `json:"address,omitempty"`
+	// Port maps the service port to the target port of the workload.
+	Port map[string]int `json:"port"`
+}
+
+// GatewayAddress points at a waypoint or network gateway, either as network/IP of its service or as
+// namespace/hostname.
+type GatewayAddress struct {
+	Destination string `json:"destination"`
+}
+
+type Locality struct {
+	Region  string `json:"region,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Subzone string `json:"subzone,omitempty"`
+}
+
+// PodWorkload returns the workload of a Kubernetes pod, or nil when ztunnel does not know the pod.
+func (d *ConfigDump) PodWorkload(namespace string, name string) *Workload {
+	for _, w := range d.Workloads {
+		if w.Namespace == namespace && w.Name == name && strings.Contains(w.UID, "/Pod/") {
+			return w
+		}
+	}
+	return nil
+}
+
+// WorkloadByUID returns the workload with the uid, or nil.
+func (d *ConfigDump) WorkloadByUID(uid string) *Workload {
+	for _, w := range d.Workloads {
+		if w.UID == uid {
+			return w
+		}
+	}
+	return nil
+}
+
+// ServicesOf returns the services that have the workload as an endpoint.
+func (d *ConfigDump) ServicesOf(workload *Workload) []*Service {
+	var services []*Service
+	for _, s := range d.Services {
+		for _, e := range s.Endpoints {
+			if e.WorkloadUID == workload.UID {
+				services = append(services, s)
+				break
+			}
+		}
+	}
+	return services
+}
+
+// Service returns the service a gateway address points to, or nil.
+func (d *ConfigDump) Service(address *GatewayAddress) *Service {
+	for _, s := range d.Services {
+		if address.Destination == s.Namespace+"/"+s.Hostname {
+			return s
+		}
+		for _, vip := range s.Addresses {
+			if StripNetwork(vip) == StripNetwork(address.Destination) {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// StripNetwork removes the network prefix ztunnel adds to addresses, e.g. network1/10.244.2.54.
+func StripNetwork(address string) string {
+	if i := strings.LastIndex(address, "/"); i >= 0 {
+		return address[i+1:]
+	}
+	return address
+}
+
+// PodName returns the namespace and name of a workload uid such as Kubernetes//Pod/bookinfo/ratings-v1-6484c4d9bb-mdxm5.
+func PodName(uid string) (string, string, bool) {
+	parts := strings.Split(uid, "/")
+	if len(parts) != 5 || parts[2] != "Pod" {
+		return "", "", false
+	}
+	return parts[3], parts[4], true
+}
+
+func unmarshalListOrMap[T any](data json.RawMessage, list *[]T) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	if data[0] == '[' {
+		return json.Unmarshal(data, list)
+	}
+	var m map[string]T
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for _, v := range m {
+		*list = append(*list, v)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	networkingv1 "istio.io/client-go/pkg/apis/networking/v1"
+	"istio.io/client-go/pkg/apis/security/v1beta1"
+	networkingk8sv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// LoadFromPath reads every YAML file found at path (a single file or a directory walked recursively)
+// and decodes the objects mesh-helper knows about. Documents of any other kind are skipped.
+func LoadFromPath(path string) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isManifestFile(file) {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		fileObjects, err := Decode(f)
+		if err != nil {
+			return fmt.Errorf("could not decode %s: %w", file, err)
+		}
+		objects = append(objects, fileObjects...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Decode reads a stream of `---` separated YAML documents.
+func Decode(r io.Reader) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, err := decodeObject(doc)
+		if err != nil {
+			return nil, err
+		}
+		if obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
+
+func decodeObject(doc []byte) (runtime.Object, error) {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(doc, &typeMeta); err != nil {
+		return nil, err
+	}
+
+	var obj runtime.Object
+	switch typeMeta.Kind {
+	case "AuthorizationPolicy":
+		obj = &v1beta1.AuthorizationPolicy{}
+	case "Sidecar":
+		obj = &networkingv1.Sidecar{}
+	case "NetworkPolicy":
+		obj = &networkingk8sv1.NetworkPolicy{}
+	default:
+		return nil, nil
+	}
+	if err := yaml.Unmarshal(doc, obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", typeMeta.Kind, err)
+	}
+	return obj, nil
+}
+
+func isManifestFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// AuthorizationPolicies returns only the AuthorizationPolicies found in objects.
+func AuthorizationPolicies(objects []runtime.Object) []*v1beta1.AuthorizationPolicy {
+	var policies []*v1beta1.AuthorizationPolicy
+	for _, obj := range objects {
+		if policy, ok := obj.(*v1beta1.AuthorizationPolicy); ok {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// Sidecars returns only the Sidecars found in objects.
+func Sidecars(objects []runtime.Object) []*networkingv1.Sidecar {
+	var sidecars []*networkingv1.Sidecar
+	for _, obj := range objects {
+		if sidecar, ok := obj.(*networkingv1.Sidecar); ok {
+			sidecars = append(sidecars, sidecar)
+		}
+	}
+	return sidecars
+}
@@ -35,6 +35,51 @@ func LoadStorageFromFile(fname string) (*teststorage.TestStorage, error) {
 }
 
 func LoadStorageFromEndpoint(server string, metric string) (*teststorage.TestStorage, error) {
+	metrics, err := queryEndpoint(server, metric, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	storage, err := loadStorageHTTP(metrics)
+	return storage, err
+}
+
+// LoadStorageFromEndpointWindow loads how much the metric increased over the window ending at the given time instead
+// of its counter totals. Series without any increase are left out, the others are stored under the metric name with
+// all of their labels.
+func LoadStorageFromEndpointWindow(server string, metric string, window time.Duration, end time.Time) (*teststorage.TestStorage, error) {
+	metrics, err := queryEndpoint(server, fmt.Sprintf("increase(%s[%s]) > 0", metric, model.Duration(window)), end)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range metrics {
+		m.Metric[model.MetricNameLabel] = model.LabelValue(metric)
+	}
+	storage, err := loadStorageHTTP(metrics)
+	return storage, err
+}
+
+// ExportFromEndpoint queries a prometheus server and returns the samples in the promtool JSON format read by
+// LoadStorageFromFile.
+func ExportFromEndpoint(server string, query string) ([]PromtoolJson, error) {
+	metrics, err := queryEndpoint(server, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	exported := make([]PromtoolJson, 0, len(metrics))
+	for _, m := range metrics {
+		labels := map[string]string{}
+		for k, v := range m.Metric {
+			labels[string(k)] = string(v)
+		}
+		exported = append(exported, PromtoolJson{
+			Metric: labels,
+			Value:  []any{float64(m.Timestamp.Unix()), m.Value.String()},
+		})
+	}
+	return exported, nil
+}
+
+func queryEndpoint(server string, query string, ts time.Time) (model.Vector, error) {
 	client, err := api.NewClient(api.Config{
 		Address: fmt.Sprintf("%s", server),
 	})
@@ -46,15 +91,14 @@ func LoadStorageFromEndpoint(server string, metric string) (*teststorage.TestSto
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, metric, time.Now())
+	result, warnings, err := v1api.Query(ctx, query, ts)
 	if err != nil {
 		return nil, fmt.Errorf("error querying Prometheus: %v", err)
 	}
 	if len(warnings) > 0 {
 		log.Printf("Warnings: %v", warnings)
 	}
-	storage, err := loadStorageHTTP(result.(model.Vector))
-	return storage, err
+	return result.(model.Vector), nil
 }
 
 func loadStorageHTTP(metrics model.Vector) (*teststorage.TestStorage, error) {
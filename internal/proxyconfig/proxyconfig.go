@@ -0,0 +1,452 @@
+package proxyconfig
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	admin "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"google.golang.org/protobuf/types/known/anypb"
+	"istio.io/istio/istioctl/pkg/util/configdump"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Listener summarizes an Envoy listener and where each of its filter chains sends traffic.
+type Listener struct {
+	Name         string         `json:"name"`
+	Address      string         `json:"address,omitempty"`
+	Port         uint32         `json:"port,omitempty"`
+	FilterChains []*FilterChain `json:"filter_chains"`
+}
+
+type FilterChain struct {
+	Match       string `json:"match,omitempty"`
+	Destination string `json:"destination"`
+}
+
+// Route is a single route of a virtual host in a route configuration.
+type Route struct {
+	RouteConfig string   `json:"route_config"`
+	VirtualHost string   `json:"virtual_host"`
+	Domains     []string `json:"domains"`
+	Name        string   `json:"name,omitempty"`
+	Match       string   `json:"match"`
+	Action      string   `json:"action"`
+	// VirtualService is the name.namespace of the Istio VirtualService the route was generated from.
+	VirtualService string `json:"virtual_service,omitempty"`
+	// Note explains parts of the match that were not evaluated when matching a URL.
+	Note string `json:"note,omitempty"`
+}
+
+type Cluster struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	LbPolicy string `json:"lb_policy"`
+	TLS      bool   `json:"tls"`
+}
+
+// Secret is a certificate or trust bundle the proxy received over SDS.
+type Secret struct {
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Status       string    `json:"status"`
+	SerialNumber string    `json:"serial_number,omitempty"`
+	Issuer       string    `json:"issuer,omitempty"`
+	NotBefore    time.Time `json:"not_before,omitempty"`
+	NotAfter     time.Time `json:"not_after,omitempty"`
+	Identities   []string  `json:"identities,omitempty"`
+}
+
+func Listeners(dump *configdump.Wrapper) ([]*Listener, error) {
+	listenerDump, err := dump.GetDynamicListenerDump(true)
+	if err != nil {
+		return nil, err
+	}
+	var listeners []*Listener
+	for _, dl := range listenerDump.GetDynamicListeners() {
+		l := &listener.Listener{}
+		if err := dl.GetActiveState().GetListener().UnmarshalTo(l); err != nil {
+			return nil, err
+		}
+		summary := &Listener{
+			Name:    l.GetName(),
+			Address: l.GetAddress().GetSocketAddress().GetAddress(),
+			Port:    l.GetAddress().GetSocketAddress().GetPortValue(),
+		}
+		chains := l.GetFilterChains()
+		if l.GetDefaultFilterChain() != nil {
+			chains = append(chains, l.GetDefaultFilterChain())
+		}
+		for _, fc := range chains {
+			summary.FilterChains = append(summary.FilterChains, &FilterChain{
+				Match:       describeFilterChainMatch(fc.GetFilterChainMatch()),
+				Destination: describeFilterChainDestination(fc),
+			})
+		}
+		listeners = append(listeners, summary)
+	}
+	return listeners, nil
+}
+
+func describeFilterChainMatch(match *listener.FilterChainMatch) string {
+	var parts []string
+	if match.GetDestinationPort() != nil {
+		parts = append(parts, fmt.Sprintf("port %d", match.GetDestinationPort().GetValue()))
+	}
+	for _, prefix := range match.GetPrefixRanges() {
+		parts = append(parts, fmt.Sprintf("ip %s/%d", prefix.GetAddressPrefix(), prefix.GetPrefixLen().GetValue()))
+	}
+	if len(match.GetServerNames()) > 0 {
+		parts = append(parts, "sni "+strings.Join(match.GetServerNames(), ","))
+	}
+	if match.GetTransportProtocol() != "" {
+		parts = append(parts, "transport "+match.GetTransportProtocol())
+	}
+	if len(match.GetApplicationProtocols()) > 0 {
+		parts = append(parts, "alpn "+strings.Join(match.GetApplicationProtocols(), ","))
+	}
+	return strings.Join(parts, "; ")
+}
+
+func describeFilterChainDestination(fc *listener.FilterChain) string {
+	for _, filter := range fc.GetFilters() {
+		config := filter.GetTypedConfig()
+		switch {
+		case config.MessageIs(&hcm.HttpConnectionManager{}):
+			manager := &hcm.HttpConnectionManager{}
+			if err := config.UnmarshalTo(manager); err != nil {
+				continue
+			}
+			if manager.GetRds() != nil {
+				return "Route: " + manager.GetRds().GetRouteConfigName()
+			}
+			return "Inline Route: " + manager.GetRouteConfig().GetName()
+		case config.MessageIs(&tcp.TcpProxy{}):
+			proxy := &tcp.TcpProxy{}
+			if err := config.UnmarshalTo(proxy); err != nil {
+				continue
+			}
+			if proxy.GetCluster() != "" {
+				return "Cluster: " + proxy.GetCluster()
+			}
+			var clusters []string
+			for _, c := range proxy.GetWeightedClusters().GetClusters() {
+				clusters = append(clusters, fmt.Sprintf("%s %d", c.GetName(), c.GetWeight()))
+			}
+			return "Clusters: " + strings.Join(clusters, ", ")
+		}
+	}
+	return "Non-HTTP/Non-TCP"
+}
+
+func Clusters(dump *configdump.Wrapper) ([]*Cluster, error) {
+	clusterDump, err := dump.GetDynamicClusterDump(true)
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*Cluster
+	for _, dc := range clusterDump.GetDynamicActiveClusters() {
+		c := &cluster.Cluster{}
+		if err := dc.GetCluster().UnmarshalTo(c); err != nil {
+			return nil, err
+		}
+		clusterType := c.GetType().String()
+		if c.GetClusterType() != nil {
+			clusterType = c.GetClusterType().GetName()
+		}
+		clusters = append(clusters, &Cluster{
+			Name:     c.GetName(),
+			Type:     clusterType,
+			LbPolicy: c.GetLbPolicy().String(),
+			TLS:      c.GetTransportSocket() != nil || len(c.GetTransportSocketMatches()) > 0,
+		})
+	}
+	return clusters, nil
+}
+
+// RouteConfigs returns the dynamic and static route configurations, sorted by name.
+func RouteConfigs(dump *configdump.Wrapper) ([]*route.RouteConfiguration, error) {
+	routeDump, err := dump.GetRouteConfigDump()
+	if err != nil {
+		return nil, err
+	}
+	var configs []*anypb.Any
+	for _, rc := range routeDump.GetDynamicRouteConfigs() {
+		configs = append(configs, rc.GetRouteConfig())
+	}
+	for _, rc := range routeDump.GetStaticRouteConfigs() {
+		configs = append(configs, rc.GetRouteConfig())
+	}
+
+	var routeConfigs []*route.RouteConfiguration
+	for _, config := range configs {
+		rc := &route.RouteConfiguration{}
+		if err := config.UnmarshalTo(rc); err != nil {
+			return nil, err
+		}
+		routeConfigs = append(routeConfigs, rc)
+	}
+	sort.Slice(routeConfigs, func(i, j int) bool {
+		return routeConfigs[i].GetName() < routeConfigs[j].GetName()
+	})
+	return routeConfigs, nil
+}
+
+// Routes flattens the route configurations into one entry per route.
+func Routes(routeConfigs []*route.RouteConfiguration) []*Route {
+	var routes []*Route
+	for _, rc := range routeConfigs {
+		for _, vh := range rc.GetVirtualHosts() {
+			for _, r := range vh.GetRoutes() {
+				routes = append(routes, newRoute(rc, vh, r))
+			}
+		}
+	}
+	return routes
+}
+
+func newRoute(rc *route.RouteConfiguration, vh *route.VirtualHost, r *route.Route) *Route {
+	return &Route{
+		RouteConfig:    rc.GetName(),
+		VirtualHost:    vh.GetName(),
+		Domains:        vh.GetDomains(),
+		Name:           r.GetName(),
+		Match:          describeRouteMatch(r.GetMatch()),
+		Action:         describeRouteAction(r),
+		VirtualService: virtualServiceName(r),
+	}
+}
+
+func describeRouteMatch(match *route.RouteMatch) string {
+	var text string
+	switch {
+	case match.GetPath() != "":
+		text = match.GetPath()
+	case match.GetPathSeparatedPrefix() != "":
+		text = match.GetPathSeparatedPrefix() + "/*"
+	case match.GetSafeRegex() != nil:
+		text = "~" + match.GetSafeRegex().GetRegex()
+	default:
+		text = match.GetPrefix() + "*"
+	}
+	if len(match.GetHeaders()) > 0 || len(match.GetQueryParameters()) > 0 {
+		text += " [+conditions]"
+	}
+	return text
+}
+
+func describeRouteAction(r *route.Route) string {
+	switch {
+	case r.GetRoute() != nil:
+		action := r.GetRoute()
+		if action.GetCluster() != "" {
+			return action.GetCluster()
+		}
+		if action.GetClusterHeader() != "" {
+			return "cluster from header " + action.GetClusterHeader()
+		}
+		var clusters []string
+		for _, c := range action.GetWeightedClusters().GetClusters() {
+			clusters = append(clusters, fmt.Sprintf("%s %d", c.GetName(), c.GetWeight().GetValue()))
+		}
+		return strings.Join(clusters, ", ")
+	case r.GetRedirect() != nil:
+		return "redirect"
+	case r.GetDirectResponse() != nil:
+		return fmt.Sprintf("direct response %d", r.GetDirectResponse().GetStatus())
+	}
+	return ""
+}
+
+// virtualServiceName reads the name.namespace of the VirtualService from the metadata Istio adds to each route,
+// e.g. /apis/networking.istio.io/v1alpha3/namespaces/default/virtual-service/reviews.
+func virtualServiceName(r *route.Route) string {
+	config := r.GetMetadata().GetFilterMetadata()["istio"].GetFields()["config"].GetStringValue()
+	parts := strings.Split(config, "/")
+	if len(parts) < 2 || !strings.Contains(config, "virtual-service") {
+		return ""
+	}
+	return parts[len(parts)-1] + "." + parts[len(parts)-3]
+}
+
+// MatchURL returns the route Envoy would pick for a request to the host, port and path. The route configuration
+// is the one of the port: "<port>" on sidecars, "http.<port>" or "https.<port>.*" on gateways. The virtual host is
+// selected by the host alone, and by host:port when that only matches the catch-all. Header and query parameter
+// conditions are not evaluated, a route that uses them is reported with a note.
+func MatchURL(routeConfigs []*route.RouteConfiguration, host string, port string, path string) (*Route, error) {
+	rc := routeConfigForPort(routeConfigs, port)
+	if rc == nil {
+		return nil, fmt.Errorf("no route configuration for port %s", port)
+	}
+	vh := selectVirtualHost(rc.GetVirtualHosts(), host)
+	if vh == nil || isCatchAll(vh) {
+		if withPort := selectVirtualHost(rc.GetVirtualHosts(), host+":"+port); withPort != nil {
+			vh = withPort
+		}
+	}
+	if vh == nil {
+		return nil, fmt.Errorf("no virtual host of route configuration %s matches %s", rc.GetName(), host)
+	}
+	for _, r := range vh.GetRoutes() {
+		if !pathMatches(r.GetMatch(), path) {
+			continue
+		}
+		match := newRoute(rc, vh, r)
+		if len(r.GetMatch().GetHeaders()) > 0 || len(r.GetMatch().GetQueryParameters()) > 0 {
+			match.Note = "header and query parameter conditions were not checked"
+		}
+		return match, nil
+	}
+	return nil, fmt.Errorf("no route of virtual host %s matches %s", vh.GetName(), path)
+}
+
+// isCatchAll reports whether the virtual host only serves the * domain, like Istio's allow_any virtual host.
+func isCatchAll(vh *route.VirtualHost) bool {
+	domains := vh.GetDomains()
+	return len(domains) == 1 && domains[0] == "*"
+}
+
+// routeConfigForPort returns the route configuration Istio generates for the listener port.
+func routeConfigForPort(routeConfigs []*route.RouteConfiguration, port string) *route.RouteConfiguration {
+	for _, rc := range routeConfigs {
+		name := rc.GetName()
+		if name == port || name == "http."+port || strings.HasPrefix(name, "https."+port+".") {
+			return rc
+		}
+	}
+	return nil
+}
+
+// selectVirtualHost picks the virtual host the way Envoy does: an exact domain first, then the longest suffix
+// wildcard (*.example.com), then the longest prefix wildcard (example.*), and finally *.
+func selectVirtualHost(virtualHosts []*route.VirtualHost, host string) *route.VirtualHost {
+	host = strings.ToLower(host)
+	var best *route.VirtualHost
+	bestRank, bestLength := 0, -1
+	for _, vh := range virtualHosts {
+		for _, domain := range vh.GetDomains() {
+			domain = strings.ToLower(domain)
+			rank, length := 0, len(domain)
+			switch {
+			case domain == host:
+				rank = 4
+			case domain == "*":
+				rank = 1
+			case strings.HasPrefix(domain, "*") && strings.HasSuffix(host, domain[1:]) && len(host) > len(domain)-1:
+				rank = 3
+			case strings.HasSuffix(domain, "*") && strings.HasPrefix(host, domain[:len(domain)-1]) && len(host) > len(domain)-1:
+				rank = 2
+			}
+			if rank > bestRank || (rank == bestRank && rank > 0 && length > bestLength) {
+				best, bestRank, bestLength = vh, rank, length
+			}
+		}
+	}
+	return best
+}
+
+func pathMatches(match *route.RouteMatch, path string) bool {
+	caseSensitive := match.GetCaseSensitive() == nil || match.GetCaseSensitive().GetValue()
+	compare := func(a, b string) (string, string) {
+		if caseSensitive {
+			return a, b
+		}
+		return strings.ToLower(a), strings.ToLower(b)
+	}
+	switch {
+	case match.GetPath() != "":
+		p, m := compare(path, match.GetPath())
+		return p == m
+	case match.GetPathSeparatedPrefix() != "":
+		p, m := compare(path, match.GetPathSeparatedPrefix())
+		return p == m || strings.HasPrefix(p, m+"/")
+	case match.GetSafeRegex() != nil:
+		// Envoy requires the regex to match the whole path
+		matched, _ := regexp.MatchString("^(?:"+match.GetSafeRegex().GetRegex()+")$", path)
+		return matched
+	default:
+		p, m := compare(path, match.GetPrefix())
+		return strings.HasPrefix(p, m)
+	}
+}
+
+func Secrets(dump *configdump.Wrapper) ([]*Secret, error) {
+	secretDump, err := dump.GetSecretConfigDump()
+	if err != nil {
+		return nil, err
+	}
+	return summarizeSecrets(secretDump.GetDynamicActiveSecrets(), secretDump.GetDynamicWarmingSecrets())
+}
+
+// ActiveSecrets reads a config dump requested with resource=dynamic_active_secrets, which only holds the secrets.
+func ActiveSecrets(dump *configdump.Wrapper) ([]*Secret, error) {
+	var active []*admin.SecretsConfigDump_DynamicSecret
+	for _, config := range dump.GetConfigs() {
+		secret := &admin.SecretsConfigDump_DynamicSecret{}
+		if err := config.UnmarshalTo(secret); err != nil {
+			return nil, err
+		}
+		active = append(active, secret)
+	}
+	return summarizeSecrets(active, nil)
+}
+
+func summarizeSecrets(active []*admin.SecretsConfigDump_DynamicSecret, warming []*admin.SecretsConfigDump_DynamicSecret) ([]*Secret, error) {
+	var secrets []*Secret
+	add := func(dynamic []*admin.SecretsConfigDump_DynamicSecret, status string) error {
+		for _, ds := range dynamic {
+			secret := &tls.Secret{}
+			if err := ds.GetSecret().UnmarshalTo(secret); err != nil {
+				return err
+			}
+			summary := &Secret{Name: ds.GetName(), Status: status}
+			var data []byte
+			switch {
+			case secret.GetTlsCertificate() != nil:
+				summary.Type = "Cert Chain"
+				data = secret.GetTlsCertificate().GetCertificateChain().GetInlineBytes()
+			case secret.GetValidationContext() != nil:
+				summary.Type = "CA"
+				data = secret.GetValidationContext().GetTrustedCa().GetInlineBytes()
+			}
+			if cert := firstCertificate(data); cert != nil {
+				summary.SerialNumber = fmt.Sprintf("%x", cert.SerialNumber)
+				summary.Issuer = cert.Issuer.String()
+				summary.NotBefore = cert.NotBefore
+				summary.NotAfter = cert.NotAfter
+				for _, uri := range cert.URIs {
+					summary.Identities = append(summary.Identities, uri.String())
+				}
+			}
+			secrets = append(secrets, summary)
+		}
+		return nil
+	}
+	if err := add(active, "ACTIVE"); err != nil {
+		return nil, err
+	}
+	if err := add(warming, "WARMING"); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func firstCertificate(data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
@@ -0,0 +1,122 @@
+package proxyconfig
+
+import (
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"testing"
+)
+
+func testVirtualHosts() []*route.VirtualHost {
+	return []*route.VirtualHost{
+		{Name: "allow_any", Domains: []string{"*"}},
+		{Name: "reviews.default.svc.cluster.local:9080", Domains: []string{"reviews.default.svc.cluster.local", "reviews", "reviews.default"}},
+		{Name: "wildcard-suffix", Domains: []string{"*.example.com"}},
+		{Name: "wildcard-suffix-longer", Domains: []string{"*.api.example.com"}},
+		{Name: "wildcard-prefix", Domains: []string{"internal.*"}},
+		{Name: "with-port", Domains: []string{"legacy:8080"}},
+	}
+}
+
+func TestSelectVirtualHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "reviews", want: "reviews.default.svc.cluster.local:9080"},
+		{host: "REVIEWS.default", want: "reviews.default.svc.cluster.local:9080"},
+		{host: "foo.example.com", want: "wildcard-suffix"},
+		{host: "v1.api.example.com", want: "wildcard-suffix-longer"},
+		{host: "example.com", want: "allow_any"},
+		{host: "internal.corp", want: "wildcard-prefix"},
+		{host: "ratings", want: "allow_any"},
+		{host: "legacy:8080", want: "with-port"},
+	}
+	for _, tt := range tests {
+		vh := selectVirtualHost(testVirtualHosts(), tt.host)
+		if vh == nil {
+			t.Errorf("selectVirtualHost(%q) = nil, want %s", tt.host, tt.want)
+			continue
+		}
+		if vh.GetName() != tt.want {
+			t.Errorf("selectVirtualHost(%q) = %s, want %s", tt.host, vh.GetName(), tt.want)
+		}
+	}
+
+	if vh := selectVirtualHost([]*route.VirtualHost{{Name: "only", Domains: []string{"reviews"}}}, "ratings"); vh != nil {
+		t.Errorf("selectVirtualHost without a match = %s, want nil", vh.GetName())
+	}
+}
+
+func prefixRoute(name string, prefix string, cluster string) *route.Route {
+	return &route.Route{
+		Name:  name,
+		Match: &route.RouteMatch{PathSpecifier: &route.RouteMatch_Prefix{Prefix: prefix}},
+		Action: &route.Route_Route{Route: &route.RouteAction{
+			ClusterSpecifier: &route.RouteAction_Cluster{Cluster: cluster},
+		}},
+	}
+}
+
+func testRouteConfigs() []*route.RouteConfiguration {
+	return []*route.RouteConfiguration{
+		{
+			Name: "9080",
+			VirtualHosts: []*route.VirtualHost{
+				{Name: "allow_any", Domains: []string{"*"}, Routes: []*route.Route{prefixRoute("allow_any", "/", "PassthroughCluster")}},
+				{Name: "reviews.default.svc.cluster.local:9080", Domains: []string{"reviews", "reviews.default.svc.cluster.local"}, Routes: []*route.Route{
+					prefixRoute("v2", "/reviews/2", "outbound|9080|v2|reviews.default.svc.cluster.local"),
+					prefixRoute("default", "/", "outbound|9080||reviews.default.svc.cluster.local"),
+				}},
+			},
+		},
+		{
+			Name: "8080",
+			VirtualHosts: []*route.VirtualHost{
+				{Name: "allow_any", Domains: []string{"*"}, Routes: []*route.Route{prefixRoute("allow_any", "/", "PassthroughCluster")}},
+				{Name: "legacy", Domains: []string{"legacy:8080"}, Routes: []*route.Route{prefixRoute("legacy", "/", "outbound|8080||legacy.default.svc.cluster.local")}},
+			},
+		},
+		{
+			Name: "http.8443",
+			VirtualHosts: []*route.VirtualHost{
+				{Name: "httpbin.example.com:80", Domains: []string{"httpbin.example.com"}, Routes: []*route.Route{prefixRoute("httpbin", "/get", "outbound|8000||httpbin.default.svc.cluster.local")}},
+			},
+		},
+	}
+}
+
+func TestMatchURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		port  string
+		path  string
+		route string
+		err   bool
+	}{
+		{name: "exact domain", host: "reviews", port: "9080", path: "/reviews/1", route: "default"},
+		{name: "first matching route", host: "reviews", port: "9080", path: "/reviews/2", route: "v2"},
+		{name: "unknown host falls through to allow_any", host: "ratings", port: "9080", path: "/", route: "allow_any"},
+		{name: "host:port domain as fallback", host: "legacy", port: "8080", path: "/", route: "legacy"},
+		{name: "gateway route configuration", host: "httpbin.example.com", port: "8443", path: "/get/foo", route: "httpbin"},
+		{name: "no route configuration for the port", host: "reviews", port: "1234", path: "/", err: true},
+		{name: "no virtual host", host: "other.example.com", port: "8443", path: "/", err: true},
+		{name: "no route", host: "httpbin.example.com", port: "8443", path: "/status", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := MatchURL(testRouteConfigs(), tt.host, tt.port, tt.path)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("MatchURL() = %s, want an error", match.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MatchURL() error: %s", err)
+			}
+			if match.Name != tt.route {
+				t.Errorf("MatchURL() = %s, want %s", match.Name, tt.route)
+			}
+		})
+	}
+}
@@ -4,11 +4,19 @@ import (
 	"context"
 	"fmt"
 	"github.com/nmnellis/mesh-helper/cmd"
+	"os"
+	"os/signal"
 )
 
 func main() {
-	err := cmd.RootCommand(context.Background()).Execute()
+	// cancel running commands on ctrl-c
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := cmd.RootCommand(ctx).Execute()
 	if err != nil {
-		fmt.Print(err)
+		fmt.Println(err)
+		stop()
+		os.Exit(1)
 	}
 }